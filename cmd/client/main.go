@@ -1,29 +1,75 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/bradyfontenot/ljw/internal/api"
 	"github.com/bradyfontenot/ljw/internal/client"
 )
 
+// clientErrCode is the exit code used by run when the client itself
+// fails, so it can't be confused with a typical job exit code.
+const clientErrCode = 255
+
+// serverEnv names the environment variable holding the server url.
+// https://host:port or unix:///path/to/socket
+const serverEnv = "LJW_SERVER"
+
+// certWarnEnv names the environment variable holding how long before
+// the client certificate expires to start warning. "0" turns it off.
+const certWarnEnv = "LJW_CERT_WARN_WINDOW"
+
+// defaultCertWarnWindow is used when certWarnEnv isn't set
+const defaultCertWarnWindow = 30 * 24 * time.Hour
+
+// timeoutErrCode is the exit code used by wait when --timeout expires.
+// matches coreutils timeout(1).
+const timeoutErrCode = 124
+
 func main() {
 
 	if len(os.Args) <= 1 {
-		fmt.Println("\nNo arguments supplied. Must supply at least one argument\n")
+		fmt.Print("\nNo arguments supplied. Must supply at least one argument\n\n")
 		printUsage()
 		return
 	}
 
+	// --namespace comes before the command and applies to all of them
+	namespace := ""
+	if os.Args[1] == "--namespace" {
+		if len(os.Args) < 4 {
+			fmt.Print("\n--namespace needs a namespace and a command\n\n")
+			printUsage()
+			return
+		}
+		namespace = os.Args[2]
+		os.Args = append(os.Args[:1], os.Args[3:]...)
+	}
+
 	appCommand := os.Args[1]
 	args := os.Args[2:]
 
-	c, err := client.New()
+	server := os.Getenv(serverEnv)
+	if server == "" {
+		server = client.DefaultServer
+	}
+	c, err := client.NewWithURL(server)
 	if err != nil {
 		fmt.Printf("Problem with authentication setup. Could not start client.\nError: %v\nShutting down...", err)
 		os.Exit(1)
 	}
+	c.SetNamespace(namespace)
+	warnCertExpiry(c)
 
 	switch appCommand {
 	case "list":
@@ -32,10 +78,28 @@ func main() {
 		status(c, args[0:])
 	case "start":
 		start(c, args[0:])
+	case "run":
+		run(c, args[0:])
 	case "stop":
 		stop(c, args[0:])
+	case "wait":
+		wait(c, args[0:])
+	case "watch":
+		watch(c, args[0:])
 	case "log":
 		log(c, args[0:])
+	case "rm":
+		rm(c, args[0:])
+	case "prune":
+		prune(c, args[0:])
+	case "quota":
+		showQuota(c, args[0:])
+	case "template":
+		template(c, args[0:])
+	case "secret":
+		secret(c, args[0:])
+	case "artifacts":
+		artifacts(c, args[0:])
 	default:
 		printUsage()
 	}
@@ -43,7 +107,7 @@ func main() {
 
 func list(c *client.Client, args []string) {
 	if len(args) > 0 {
-		fmt.Println("\nToo many args. list takes no arguments.\n")
+		fmt.Print("\nToo many args. list takes no arguments.\n\n")
 		printUsage()
 		return
 	}
@@ -69,19 +133,184 @@ func status(c *client.Client, args []string) {
 }
 
 func start(c *client.Client, args []string) {
+	opts, args, err := parseStartOptions(args)
+	if err != nil {
+		fmt.Printf("\n%v\n\n", err)
+		printUsage()
+		return
+	}
+
 	if len(args) < 1 {
-		fmt.Println("\nNo linux command supplied. Must supply a command\n")
+		fmt.Print("\nNo linux command supplied. Must supply a command\n\n")
 		printUsage()
 		return
 	}
 
-	err := c.StartJob(args)
+	err = c.StartJob(args, opts)
 	if err != nil {
 		printError(err)
 		return
 	}
 }
 
+// run starts a job, streams its output and exits with the job's exit
+// code. Ctrl-C asks the server to stop the job rather than abandoning it.
+// a second Ctrl-C quits right away.
+func run(c *client.Client, args []string) {
+	opts, args, err := parseStartOptions(args)
+	if err != nil {
+		fmt.Printf("\n%v\n\n", err)
+		printUsage()
+		os.Exit(clientErrCode)
+	}
+
+	if len(args) < 1 {
+		fmt.Print("\nNo linux command supplied. Must supply a command\n\n")
+		printUsage()
+		os.Exit(clientErrCode)
+	}
+
+	ctx, cancel := interruptible()
+	defer cancel()
+
+	code, err := c.RunJob(ctx, args, opts)
+	if err != nil {
+		printError(err)
+		os.Exit(clientErrCode)
+	}
+	os.Exit(code)
+}
+
+// interruptible returns a context canceled by the first Ctrl-C, which
+// makes run stop the job. a second Ctrl-C quits without waiting for it.
+func interruptible() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+		<-sig
+		fmt.Fprintln(os.Stderr, "[FORCE QUIT] => the job may still be running")
+		os.Exit(clientErrCode)
+	}()
+	return ctx, cancel
+}
+
+// wait blocks until the given jobs end and exits with the exit code
+// reported by client.WaitJobs. usage: wait <id...> [--any|--all] [--timeout <duration>]
+func wait(c *client.Client, args []string) {
+	var ids []string
+	first := false
+	timeout := time.Duration(0)
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--any":
+			first = true
+		case arg == "--all":
+			first = false
+		case arg == "--timeout" || strings.HasPrefix(arg, "--timeout="):
+			v := strings.TrimPrefix(arg, "--timeout=")
+			if arg == "--timeout" {
+				if i+1 >= len(args) {
+					fmt.Print("\n--timeout needs a duration. e.g. 30s or 5m\n\n")
+					printUsage()
+					os.Exit(clientErrCode)
+				}
+				i++
+				v = args[i]
+			}
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				fmt.Printf("\n%s is not a valid timeout. e.g. 30s or 5m\n\n", v)
+				printUsage()
+				os.Exit(clientErrCode)
+			}
+			timeout = d
+		default:
+			ids = append(ids, arg)
+		}
+	}
+
+	if len(ids) < 1 {
+		fmt.Print("\nNo id supplied.\n\n")
+		printUsage()
+		os.Exit(clientErrCode)
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	code, err := c.WaitJobs(ctx, ids, first)
+	if err != nil {
+		printError(err)
+		if errors.Is(err, client.ErrWaitTimeout) {
+			os.Exit(timeoutErrCode)
+		}
+		os.Exit(clientErrCode)
+	}
+	os.Exit(code)
+}
+
+// watch prints the job event feed until interrupted.
+// usage: watch [--job <id>] [--owner <name>] [--label key=value]... [--cursor <event id>]
+func watch(c *client.Client, args []string) {
+	filter := url.Values{}
+	cursor := ""
+
+	for i := 0; i < len(args); i++ {
+		var param string
+		switch args[i] {
+		case "--job":
+			param = "job"
+		case "--owner":
+			param = "owner"
+		case "--label":
+			param = "label"
+		case "--cursor":
+			param = "cursor"
+		default:
+			fmt.Printf("\n%s is not a valid watch option\n\n", args[i])
+			printUsage()
+			return
+		}
+
+		if i+1 >= len(args) {
+			fmt.Printf("\n%s needs a value\n\n", args[i])
+			printUsage()
+			return
+		}
+		i++
+
+		if param == "cursor" {
+			cursor = args[i]
+		} else {
+			filter.Add(param, args[i])
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	if err := c.WatchEvents(ctx, filter, cursor); err != nil {
+		printError(err)
+	}
+}
+
 func stop(c *client.Client, args []string) {
 	id, err := processID(args)
 	if err != nil {
@@ -108,18 +337,337 @@ func log(c *client.Client, args []string) {
 	}
 }
 
+// parseStartOptions pulls leading --label key=value, --webhook <url>,
+// --secret ENV=name, --secret-file ENV=name, --dir <path> and
+// --artifact <glob> options off args and returns them along with the
+// remaining args.
+func parseStartOptions(args []string) (client.StartOptions, []string, error) {
+	var opts client.StartOptions
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		if len(args) < 2 {
+			return opts, nil, fmt.Errorf("%s needs a value", args[0])
+		}
+
+		switch args[0] {
+		case "--label":
+			kv := strings.SplitN(args[1], "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return opts, nil, fmt.Errorf("%s is not a valid label. use key=value", args[1])
+			}
+			if opts.Labels == nil {
+				opts.Labels = make(map[string]string)
+			}
+			opts.Labels[kv[0]] = kv[1]
+		case "--webhook":
+			opts.Webhooks = append(opts.Webhooks, args[1])
+		case "--secret", "--secret-file":
+			kv := strings.SplitN(args[1], "=", 2)
+			if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+				return opts, nil, fmt.Errorf("%s is not a valid secret. use ENV=name", args[1])
+			}
+			opts.Secrets = append(opts.Secrets, api.SecretRef{Env: kv[0], Name: kv[1], File: args[0] == "--secret-file"})
+		case "--dir":
+			opts.Dir = args[1]
+		case "--artifact":
+			opts.Artifacts = append(opts.Artifacts, args[1])
+		default:
+			return opts, nil, fmt.Errorf("%s is not a valid option", args[0])
+		}
+		args = args[2:]
+	}
+	return opts, args, nil
+}
+
+func rm(c *client.Client, args []string) {
+	id, err := processID(args)
+	if err != nil {
+		return
+	}
+
+	err = c.RemoveJob(id)
+	if err != nil {
+		printError(err)
+		return
+	}
+}
+
+// prune removes ended jobs. without options the server's retention
+// policy is used. usage: prune [--max-age <duration>] [--max-count <n>] [--max-log-bytes <n>]
+func prune(c *client.Client, args []string) {
+	var opts client.PruneOptions
+
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			fmt.Printf("\n%s needs a value\n\n", args[i])
+			printUsage()
+			return
+		}
+
+		var err error
+		switch args[i] {
+		case "--max-age":
+			_, err = time.ParseDuration(args[i+1])
+			opts.MaxAge = args[i+1]
+		case "--max-count":
+			opts.MaxCount, err = strconv.Atoi(args[i+1])
+		case "--max-log-bytes":
+			opts.MaxLogBytes, err = strconv.Atoi(args[i+1])
+		default:
+			fmt.Printf("\n%s is not a valid prune option\n\n", args[i])
+			printUsage()
+			return
+		}
+		if err != nil {
+			fmt.Printf("\n%s is not a valid value for %s\n\n", args[i+1], args[i])
+			printUsage()
+			return
+		}
+	}
+
+	err := c.PruneJobs(opts)
+	if err != nil {
+		printError(err)
+		return
+	}
+}
+
+// showQuota prints the client's limits and what it is using of them
+func showQuota(c *client.Client, args []string) {
+	if len(args) > 0 {
+		fmt.Print("\nToo many args. quota takes no arguments.\n\n")
+		printUsage()
+		return
+	}
+
+	err := c.Quota()
+	if err != nil {
+		printError(err)
+		return
+	}
+}
+
+// template manages job templates.
+// usage: template create <name> [--param <name>[=default]]... [--env KEY=value]... [--label key=value]... [--timeout <duration>] <linux cmd>
+// template list
+// template run [--label key=value]... [--secret ENV=name]... <name> [key=value]...
+func template(c *client.Client, args []string) {
+	if len(args) < 1 {
+		fmt.Print("\nNo template command supplied. Must be create, list or run\n\n")
+		printUsage()
+		return
+	}
+
+	switch args[0] {
+	case "create":
+		req, err := parseTemplate(args[1:])
+		if err != nil {
+			fmt.Printf("\n%v\n\n", err)
+			printUsage()
+			return
+		}
+		if err := c.CreateTemplate(req); err != nil {
+			printError(err)
+		}
+	case "list":
+		if err := c.ListTemplates(); err != nil {
+			printError(err)
+		}
+	case "run":
+		opts, args, err := parseStartOptions(args[1:])
+		if err != nil {
+			fmt.Printf("\n%v\n\n", err)
+			printUsage()
+			os.Exit(clientErrCode)
+		}
+		if len(args) < 1 {
+			fmt.Print("\nNo template name supplied.\n\n")
+			printUsage()
+			os.Exit(clientErrCode)
+		}
+		params := make(map[string]string)
+		for _, arg := range args[1:] {
+			kv := strings.SplitN(arg, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				fmt.Printf("\n%s is not a valid parameter. use key=value\n\n", arg)
+				printUsage()
+				os.Exit(clientErrCode)
+			}
+			params[kv[0]] = kv[1]
+		}
+
+		ctx, cancel := interruptible()
+		defer cancel()
+
+		code, err := c.RunTemplate(ctx, args[0], params, opts)
+		if err != nil {
+			printError(err)
+			os.Exit(clientErrCode)
+		}
+		os.Exit(code)
+	default:
+		fmt.Printf("\n%s is not a template command. Must be create, list or run\n\n", args[0])
+		printUsage()
+	}
+}
+
+// secret manages secrets. the value of set is read from stdin so it
+// doesn't end up in the shell history or the process list.
+// usage: secret set <name> < value
+// secret list
+// secret rm <name>
+func secret(c *client.Client, args []string) {
+	if len(args) < 1 {
+		fmt.Print("\nNo secret command supplied. Must be set, list or rm\n\n")
+		printUsage()
+		return
+	}
+
+	var err error
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		err = c.ListSecrets()
+	case args[0] == "set" && len(args) == 2:
+		var value []byte
+		value, err = ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			break
+		}
+		// drop the newline echo or a heredoc leaves behind
+		v := strings.TrimSuffix(strings.TrimSuffix(string(value), "\n"), "\r")
+		err = c.SetSecret(args[1], v)
+	case args[0] == "rm" && len(args) == 2:
+		err = c.DeleteSecret(args[1])
+	default:
+		fmt.Print("\nInvalid secret command. Must be set <name>, list or rm <name>\n\n")
+		printUsage()
+		return
+	}
+	if err != nil {
+		printError(err)
+	}
+}
+
+// artifacts lists and downloads the files kept from a job.
+// usage: artifacts list <id>
+// artifacts get <id> <path> [dest]
+func artifacts(c *client.Client, args []string) {
+	var err error
+	switch {
+	case len(args) == 2 && args[0] == "list":
+		err = c.ListArtifacts(args[1])
+	case (len(args) == 3 || len(args) == 4) && args[0] == "get":
+		dest := ""
+		if len(args) == 4 {
+			dest = args[3]
+		}
+		err = c.GetArtifact(args[1], args[2], dest)
+	default:
+		fmt.Print("\nInvalid artifacts command. Must be list <id> or get <id> <path> [dest]\n\n")
+		printUsage()
+		return
+	}
+	if err != nil {
+		printError(err)
+	}
+}
+
+// parseTemplate reads the name, options and command of template create
+func parseTemplate(args []string) (api.CreateTemplateRequest, error) {
+	var req api.CreateTemplateRequest
+	if len(args) < 1 {
+		return req, errors.New("No template name supplied.")
+	}
+	req.Name = args[0]
+	args = args[1:]
+
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		if len(args) < 2 {
+			return req, fmt.Errorf("%s needs a value", args[0])
+		}
+
+		switch args[0] {
+		case "--param":
+			kv := strings.SplitN(args[1], "=", 2)
+			if req.Params == nil {
+				req.Params = make(map[string]api.TemplateParam)
+			}
+			var p api.TemplateParam
+			if len(kv) == 2 {
+				p.Default = &kv[1]
+			}
+			req.Params[kv[0]] = p
+		case "--env", "--label":
+			kv := strings.SplitN(args[1], "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return req, fmt.Errorf("%s is not a valid %s. use key=value", args[1], args[0][2:])
+			}
+			if args[0] == "--env" {
+				if req.Env == nil {
+					req.Env = make(map[string]string)
+				}
+				req.Env[kv[0]] = kv[1]
+			} else {
+				if req.Labels == nil {
+					req.Labels = make(map[string]string)
+				}
+				req.Labels[kv[0]] = kv[1]
+			}
+		case "--timeout":
+			req.Limits = &api.JobLimits{Timeout: args[1]}
+		default:
+			return req, fmt.Errorf("%s is not a valid option", args[0])
+		}
+		args = args[2:]
+	}
+
+	if len(args) < 1 {
+		return req, errors.New("No linux command supplied. Must supply a command")
+	}
+	req.Cmd = args
+	return req, nil
+}
+
+// warnCertExpiry prints a warning to stderr when the client certificate
+// expires within the window set by certWarnEnv
+func warnCertExpiry(c *client.Client) {
+	window := defaultCertWarnWindow
+	if v := os.Getenv(certWarnEnv); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[WARNING] %s=%q is not a duration. using %v\n", certWarnEnv, v, window)
+		} else {
+			window = d
+		}
+	}
+	if window <= 0 {
+		return
+	}
+
+	expiry := c.CertExpiry()
+	if expiry.IsZero() {
+		return
+	}
+	left := time.Until(expiry)
+	switch {
+	case left <= 0:
+		fmt.Fprintf(os.Stderr, "[WARNING] client certificate expired %s. ask for a new one\n", expiry.Format(time.RFC3339))
+	case left < window:
+		fmt.Fprintf(os.Stderr, "[WARNING] client certificate expires %s, in %d days. ask for a new one\n", expiry.Format(time.RFC3339), int(left.Hours()/24))
+	}
+}
+
 func printUsage() {
-	fmt.Println("[USAGE]")
-	fmt.Printf(" list\n start \t<linux cmd>\n status\t<job id>\n stop \t<job id>\n log \t<job id>\n\n")
+	fmt.Println("[USAGE] [--namespace <ns>] <command>")
+	fmt.Printf(" list\n start \t[--label key=value]... [--webhook <url>]... [--secret ENV=name]... [--secret-file ENV=name]... [--dir <path>] [--artifact <glob>]... <linux cmd>\n run \t[--label key=value]... [--webhook <url>]... [--secret ENV=name]... [--secret-file ENV=name]... [--dir <path>] [--artifact <glob>]... <linux cmd>\n status\t<job id>\n stop \t<job id>\n log \t<job id>\n rm \t<job id>\n prune \t[--max-age <duration>] [--max-count <n>] [--max-log-bytes <n>]\n wait \t<job id...> [--any|--all] [--timeout <duration>]\n watch \t[--job <id>] [--owner <name>] [--label key=value]... [--cursor <event id>]\n quota\n template create <name> [--param <name>[=default]]... [--env KEY=value]... [--label key=value]... [--timeout <duration>] <linux cmd>\n template list\n template run [--label key=value]... [--secret ENV=name]... <name> [key=value]...\n secret set <name> (value read from stdin)\n secret list\n secret rm <name>\n artifacts list <job id>\n artifacts get <job id> <path> [dest]\n\n")
 }
 
 func processID(args []string) (string, error) {
 	if len(args) < 1 {
-		fmt.Println("\nNo id supplied.\n")
+		fmt.Print("\nNo id supplied.\n\n")
 		printUsage()
 		return "", errors.New("no argument supplied")
 	} else if len(args) > 1 {
-		fmt.Println("\nToo many args or id has spaces. Please Supply only one id at a time.\n")
+		fmt.Print("\nToo many args or id has spaces. Please Supply only one id at a time.\n\n")
 		printUsage()
 		return "", errors.New("too many arguments supplied. only needs 1")
 	}
@@ -128,5 +676,21 @@ func processID(args []string) (string, error) {
 }
 
 func printError(err error) {
-	fmt.Printf("\n[Error]\n%v \n\n", err)
+	fmt.Printf("\n[Error]\n%v \n", err)
+
+	var apiErr *api.Error
+	if errors.As(err, &apiErr) {
+		for field, msg := range apiErr.Details {
+			if msg != apiErr.Message {
+				fmt.Printf(" -%s: %s\n", field, msg)
+			}
+		}
+		if apiErr.RetryAfter > 0 {
+			fmt.Printf("[RETRY AFTER] %v\n", apiErr.RetryAfter)
+		}
+		if apiErr.RequestID != "" {
+			fmt.Printf("[REQUEST ID] %s\n", apiErr.RequestID)
+		}
+	}
+	fmt.Println()
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bradyfontenot/ljw/internal/pki"
+)
+
+const day = 24 * time.Hour
+
+// stringList is a flag that may be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "pki" {
+		printUsage()
+		os.Exit(2)
+	}
+	if len(os.Args) < 3 {
+		fmt.Print("\nNo pki command supplied.\n\n")
+		printUsage()
+		os.Exit(2)
+	}
+
+	var err error
+	cmd, args := os.Args[2], os.Args[3:]
+	switch cmd {
+	case "init":
+		err = initCA(args)
+	case "server":
+		err = server(args)
+	case "client":
+		err = client(args)
+	case "list":
+		err = list(args)
+	case "revoke":
+		err = revoke(args)
+	case "crl":
+		err = crl(args)
+	default:
+		printUsage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Printf("\n[Error]\n%v\n\n", err)
+		os.Exit(1)
+	}
+}
+
+// flags returns a flag set for a pki command with the shared --dir flag
+func flags(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet("pki "+name, flag.ExitOnError)
+	dir := fs.String("dir", "ssl", "directory holding the CA and issued certificates")
+	return fs, dir
+}
+
+func initCA(args []string) error {
+	fs, dir := flags("init")
+	cn := fs.String("cn", "ljw CA", "common name of the CA")
+	days := fs.Int("days", 3650, "days the CA is valid")
+	force := fs.Bool("force", false, "replace an existing CA. every certificate it issued stops working")
+	fs.Parse(args)
+
+	rec, err := pki.New(*dir).Init(*cn, time.Duration(*days)*day, *force)
+	if errors.Is(err, pki.ErrExists) {
+		return fmt.Errorf("%v in %s. use --force to replace it", err, *dir)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nCreated CA %q in %s. expires %s\n\n", rec.CN, *dir, rec.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+func server(args []string) error {
+	fs, dir := flags("server")
+	var sans stringList
+	fs.Var(&sans, "san", "host name or ip address the server is reached at. may be repeated")
+	out := fs.String("out", "server", "name of the certificate and key files")
+	days := fs.Int("days", 365, "days the certificate is valid")
+	fs.Parse(args)
+
+	if len(sans) == 0 {
+		sans = stringList{"localhost", "127.0.0.1"}
+	}
+	rec, err := pki.New(*dir).IssueServer(*out, sans, time.Duration(*days)*day)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nIssued server certificate %s for %s. expires %s\n\n", rec.Serial, strings.Join(rec.SANs, ", "), rec.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+func client(args []string) error {
+	fs, dir := flags("client")
+	cn := fs.String("cn", "", "common name of the client. becomes its identity on the server")
+	var ou stringList
+	fs.Var(&ou, "ou", "organizational unit. may be repeated")
+	out := fs.String("out", "", "name of the certificate and key files. defaults to the common name")
+	days := fs.Int("days", 365, "days the certificate is valid")
+	fs.Parse(args)
+
+	if *cn == "" {
+		return errors.New("--cn is required")
+	}
+	if *out == "" {
+		*out = *cn
+	}
+	rec, err := pki.New(*dir).IssueClient(*out, *cn, ou, time.Duration(*days)*day)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nIssued client certificate %s for %q. expires %s\n\n", rec.Serial, rec.CN, rec.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+func list(args []string) error {
+	fs, dir := flags("list")
+	fs.Parse(args)
+
+	index, err := pki.New(*dir).List()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERIAL\tKIND\tCN\tOU\tEXPIRES\tSTATUS")
+	now := time.Now()
+	for _, rec := range index {
+		status := "valid"
+		switch {
+		case rec.Revoked():
+			status = "revoked"
+			if rec.Reason != "" {
+				status += " (" + rec.Reason + ")"
+			}
+		case now.After(rec.NotAfter):
+			status = "expired"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", rec.Serial, rec.Kind, rec.CN, strings.Join(rec.OU, ","), rec.NotAfter.Format("2006-01-02"), status)
+	}
+	return tw.Flush()
+}
+
+func revoke(args []string) error {
+	fs, dir := flags("revoke")
+	reason := fs.String("reason", "", "why the certificate is revoked")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("revoke takes one serial. see pki list")
+	}
+	rec, err := pki.New(*dir).Revoke(fs.Arg(0), *reason)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nRevoked %s certificate %s for %q. CRL written to %s/%s\n\n", rec.Kind, rec.Serial, rec.CN, *dir, pki.CRLFile)
+	return nil
+}
+
+func crl(args []string) error {
+	fs, dir := flags("crl")
+	fs.Parse(args)
+
+	if err := pki.New(*dir).WriteCRL(); err != nil {
+		return err
+	}
+	fmt.Printf("\nCRL written to %s/%s\n\n", *dir, pki.CRLFile)
+	return nil
+}
+
+func printUsage() {
+	fmt.Println("[USAGE]")
+	fmt.Printf(" pki init \t[--dir <dir>] [--cn <name>] [--days <n>] [--force]\n pki server \t[--dir <dir>] [--san <host|ip>]... [--out <name>] [--days <n>]\n pki client \t[--dir <dir>] --cn <name> [--ou <unit>]... [--out <name>] [--days <n>]\n pki list \t[--dir <dir>]\n pki revoke \t[--dir <dir>] [--reason <text>] <serial>\n pki crl \t[--dir <dir>]\n\n")
+}
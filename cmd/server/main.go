@@ -1,21 +1,310 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/bradyfontenot/ljw/internal/audit"
+	"github.com/bradyfontenot/ljw/internal/namespace"
+	"github.com/bradyfontenot/ljw/internal/policy"
+	"github.com/bradyfontenot/ljw/internal/quota"
+	"github.com/bradyfontenot/ljw/internal/redact"
+	"github.com/bradyfontenot/ljw/internal/secrets"
 	"github.com/bradyfontenot/ljw/internal/server"
+	"github.com/bradyfontenot/ljw/internal/templates"
 	"github.com/bradyfontenot/ljw/internal/worker"
 )
 
+// apiShutdownTimeout is how long in-flight API requests get to finish
+const apiShutdownTimeout = 10 * time.Second
+
+// stringList is a flag that may be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
 func main() {
+	var webhooks stringList
+	flag.Var(&webhooks, "webhook", "url POSTed to when any job ends. may be repeated")
+	webhookSecretFile := flag.String("webhook-secret-file", "", "file holding the key used to sign webhook payloads")
+	var webhookHosts stringList
+	flag.Var(&webhookHosts, "webhook-allow-host", "host or host:port the webhooks of jobs may call even if it is a loopback, private or link-local address. may be repeated")
+	var retention worker.RetentionPolicy
+	flag.DurationVar(&retention.MaxAge, "retain-max-age", 0, "remove jobs that ended longer ago than this. 0 keeps them")
+	flag.IntVar(&retention.MaxCount, "retain-max-count", 0, "keep at most this many ended jobs. 0 is unlimited")
+	flag.IntVar(&retention.MaxLogBytes, "retain-max-log-bytes", 0, "cap on total output kept by ended jobs. 0 is unlimited")
+	reapInterval := flag.Duration("reap-interval", time.Minute, "how often the retention policy is enforced")
+	adminAddr := flag.String("admin-addr", "", "address for the admin listener serving /metrics. disabled if empty")
+	adminTLS := flag.Bool("admin-tls", false, "serve the admin listener over TLS with the server certificate")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "how long running jobs get to finish on shutdown before they are terminated")
+	policyFile := flag.String("policy-file", "", "JSON file of rules deciding which commands clients may run. all commands are allowed if empty")
+	quotaFile := flag.String("quota-file", "", "JSON file of per-identity rate limits and job quotas. nothing is limited if empty")
+	namespaceFile := flag.String("namespace-file", "", "JSON file of namespaces with their grants, job limits and quotas. only the default namespace exists if empty")
+	auditFile := flag.String("audit-log", "", "file every API action is appended to as JSON lines. disabled if empty")
+	auditChain := flag.Bool("audit-chain", false, "hash chain audit log entries so changes to the log can be detected")
+	var admins stringList
+	flag.Var(&admins, "admin-identity", "client identity allowed to use admin endpoints such as the audit log. may be repeated")
+	grpcAddr := flag.String("grpc-addr", "", "address the job API is also served on over gRPC. disabled if empty")
+	unixSocket := flag.String("unix-socket", "", "unix socket the API is also served on for local clients. disabled if empty")
+	var unixIdentities stringList
+	flag.Var(&unixIdentities, "unix-identity", "uid=identity giving a local user access to the unix socket as identity. may be repeated")
+	unixMode := flag.String("unix-socket-mode", "0660", "permissions of the unix socket file, in octal")
+	unixServerUID := flag.Bool("unix-allow-server-uid", false, "let -unix-identity map the uid the server runs as. jobs run as it too and could use the socket")
+	tlsMin := flag.String("tls-min-version", "1.3", "oldest TLS version clients may use. 1.2 or 1.3")
+	crlFile := flag.String("crl-file", "", "CRL signed by the CA. client certificates it revokes are rejected. disabled if empty")
+	crlReload := flag.Duration("crl-reload", time.Minute, "how often the CRL file is checked for changes")
+	dataDir := flag.String("data-dir", "", "directory ended jobs, job templates and secrets are kept in across restarts. they are only kept in memory if empty")
+	secretKeyFile := flag.String("secret-key-file", "", "file holding the 32 byte hex master key secrets are encrypted with. secrets are disabled if empty")
+	var jobRoots stringList
+	flag.Var(&jobRoots, "job-root", "directory jobs may set as their working directory, along with the directories inside it. may be repeated. jobs can't set one, or keep artifacts, if unset")
+	artifactDir := flag.String("artifact-dir", "", "directory job artifacts are kept in. defaults to artifacts in -data-dir. artifacts are disabled if both are empty")
+	artifactMaxBytes := flag.Int64("artifact-max-bytes", 100<<20, "total bytes of artifacts each job may keep. 0 doesn't limit")
+	artifactMaxFiles := flag.Int("artifact-max-files", 1000, "number of artifact files each job may keep. 0 doesn't limit")
+	redactFile := flag.String("redact-file", "", "JSON file of regex rules hiding credentials in job output before it is stored. nothing is hidden if empty")
+	flag.Parse()
+
+	webhookCfg := worker.WebhookConfig{AllowHosts: webhookHosts}
+	for _, url := range webhooks {
+		webhookCfg.Rules = append(webhookCfg.Rules, worker.WebhookRule{URL: url})
+	}
+	if *webhookSecretFile != "" {
+		secret, err := ioutil.ReadFile(*webhookSecretFile)
+		if err != nil {
+			fmt.Printf("Could not read webhook secret.\nError: %v\nShutting down...", err)
+			os.Exit(1)
+		}
+		webhookCfg.Secret = bytes.TrimSpace(secret)
+	}
+
+	wkrOpts := []worker.Option{
+		worker.WithWebhooks(webhookCfg),
+		worker.WithRetention(retention),
+	}
+	if *dataDir != "" {
+		wkrOpts = append(wkrOpts, worker.WithDataDir(*dataDir))
+	}
+	if len(jobRoots) > 0 {
+		wkrOpts = append(wkrOpts, worker.WithJobRoots(jobRoots...))
+	}
+	if *artifactDir == "" && *dataDir != "" {
+		*artifactDir = filepath.Join(*dataDir, "artifacts")
+	}
+	if *artifactDir != "" {
+		wkrOpts = append(wkrOpts, worker.WithArtifacts(worker.ArtifactConfig{
+			Dir:      *artifactDir,
+			MaxBytes: *artifactMaxBytes,
+			MaxFiles: *artifactMaxFiles,
+		}))
+	}
+	if *redactFile != "" {
+		rules, err := redact.Load(*redactFile)
+		if err != nil {
+			fmt.Printf("Could not load redaction rules.\nError: %v\nShutting down...", err)
+			os.Exit(1)
+		}
+		wkrOpts = append(wkrOpts, worker.WithRedaction(rules))
+	}
+
+	wkr := worker.New(wkrOpts...)
+	if err := wkr.LoadJobs(); err != nil {
+		fmt.Printf("Could not load stored jobs.\nError: %v\nShutting down...", err)
+		os.Exit(1)
+	}
+	go wkr.RunReaper(context.Background(), *reapInterval)
+
+	minTLS, err := server.ParseTLSVersion(*tlsMin)
+	if err != nil {
+		fmt.Printf("Invalid -tls-min-version.\nError: %v\nShutting down...", err)
+		os.Exit(1)
+	}
+	opts := []server.Option{server.WithMinTLSVersion(minTLS)}
+	if *adminAddr != "" {
+		opts = append(opts, server.WithAdmin(*adminAddr, *adminTLS))
+	}
+	if *policyFile != "" {
+		p, err := policy.Load(*policyFile)
+		if err != nil {
+			fmt.Printf("Could not load policy.\nError: %v\nShutting down...", err)
+			os.Exit(1)
+		}
+		opts = append(opts, server.WithPolicy(p))
+	}
+	if *quotaFile != "" {
+		q, err := quota.Load(*quotaFile)
+		if err != nil {
+			fmt.Printf("Could not load quotas.\nError: %v\nShutting down...", err)
+			os.Exit(1)
+		}
+		opts = append(opts, server.WithQuotas(q))
+	}
+	if *namespaceFile != "" {
+		n, err := namespace.Load(*namespaceFile)
+		if err != nil {
+			fmt.Printf("Could not load namespaces.\nError: %v\nShutting down...", err)
+			os.Exit(1)
+		}
+		opts = append(opts, server.WithNamespaces(n))
+	}
+	if *dataDir != "" {
+		store, err := templates.Open(filepath.Join(*dataDir, "templates.json"))
+		if err != nil {
+			fmt.Printf("Could not load job templates.\nError: %v\nShutting down...", err)
+			os.Exit(1)
+		}
+		opts = append(opts, server.WithTemplates(store))
+	}
+	if *secretKeyFile != "" {
+		store, err := openSecrets(*secretKeyFile, *dataDir)
+		if err != nil {
+			fmt.Printf("Could not load secrets.\nError: %v\nShutting down...", err)
+			os.Exit(1)
+		}
+		opts = append(opts, server.WithSecrets(store))
+	}
+	if *auditFile != "" {
+		auditLog, err := audit.Open(*auditFile, *auditChain)
+		if err != nil {
+			fmt.Printf("Could not open audit log.\nError: %v\nShutting down...", err)
+			os.Exit(1)
+		}
+		defer auditLog.Close()
+		opts = append(opts, server.WithAudit(auditLog))
+	}
+	if *crlFile != "" {
+		opts = append(opts, server.WithCRL(*crlFile, *crlReload))
+	}
+	if *grpcAddr != "" {
+		opts = append(opts, server.WithGRPC(*grpcAddr))
+	}
+	if *unixSocket != "" {
+		identities := make(map[uint32]string)
+		for _, v := range unixIdentities {
+			uid, id, err := server.ParseUnixIdentity(v)
+			if err != nil {
+				fmt.Printf("Invalid -unix-identity.\nError: %v\nShutting down...", err)
+				os.Exit(1)
+			}
+			identities[uid] = id
+		}
+		mode, err := strconv.ParseUint(*unixMode, 8, 32)
+		if err != nil || mode > 0777 {
+			fmt.Printf("Invalid -unix-socket-mode %s. it must be octal permissions such as 0660.\nShutting down...", *unixMode)
+			os.Exit(1)
+		}
+		opts = append(opts, server.WithUnixSocket(*unixSocket, identities), server.WithUnixSocketMode(os.FileMode(mode)))
+		if *unixServerUID {
+			opts = append(opts, server.WithUnixServerUID())
+		}
+	}
+	opts = append(opts, server.WithAdmins(admins...))
 
-	srv, err := server.New(worker.New())
+	srv, err := server.New(wkr, opts...)
 	if err != nil {
 		fmt.Printf("Problem with authentication setup. Could not start server.\nError: %v\nShutting down...", err)
 		os.Exit(1)
 	}
 
-	log.Fatal(srv.ListenAndServeTLS("", ""))
+	if srv.Admin != nil {
+		go func() {
+			if err := srv.ServeAdmin(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if srv.GRPC != nil {
+		go func() {
+			if err := srv.ServeGRPC(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if srv.Unix != nil {
+		go func() {
+			if err := srv.ServeUnix(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	go func() {
+		if err := srv.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// certificates are reloaded when their files change. SIGHUP
+	// reloads them, and the CRL, right away.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := srv.ReloadTLS(); err != nil {
+				log.Printf("could not reload certificates. keeping current ones. error: %v", err)
+			}
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	log.Printf("received %v. shutting down", <-sig)
+
+	shutdown(srv, wkr, *drainTimeout)
+}
+
+// shutdown stops taking new jobs and API requests, gives running jobs
+// drainTimeout to finish, then terminates whatever is left and stores
+// the final state of every job.
+func shutdown(srv *server.Server, wkr *worker.Worker, drainTimeout time.Duration) {
+	// readiness fails from here on
+	wkr.Drain()
+
+	ctx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("could not shut down api listener cleanly. error: %v", err)
+	}
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancelDrain()
+	wkr.Shutdown(drainCtx)
+
+	if srv.Admin != nil {
+		srv.Admin.Close()
+	}
+	log.Print("shutdown complete")
+}
+
+// openSecrets opens the secret store sealed with the key in keyFile.
+// it is kept in dataDir, or in memory if dataDir is empty.
+func openSecrets(keyFile, dataDir string) (*secrets.Store, error) {
+	key, err := secrets.LoadKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	file := ""
+	if dataDir != "" {
+		file = filepath.Join(dataDir, "secrets.json")
+	}
+	return secrets.Open(file, key)
 }
@@ -0,0 +1,14 @@
+package api
+
+// Artifact is a file kept from a job's working directory
+type Artifact struct {
+	// Path is relative to the working directory, e.g. reports/junit.xml
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// ArtifactList is the body of GET /api/v1/jobs/:id/artifacts
+type ArtifactList struct {
+	ID        string     `json:"id"`
+	Artifacts []Artifact `json:"artifacts"`
+}
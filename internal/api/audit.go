@@ -0,0 +1,28 @@
+package api
+
+import "time"
+
+// AuditEntry records one API action
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	Identity   string    `json:"identity"`
+	RemoteAddr string    `json:"remoteAddr"`
+	RequestID  string    `json:"requestId"`
+	Action     string    `json:"action"`
+	JobID      string    `json:"jobId"`
+	Cmd        []string  `json:"cmd"`
+	Status     int       `json:"status"`
+	// Outcome is "ok" or "error"
+	Outcome string `json:"outcome"`
+	// Error is the error code of a failed action
+	Error string `json:"error"`
+	// PrevHash and Hash chain entries together when the server
+	// keeps a hash chained log. otherwise they are empty.
+	PrevHash string `json:"prevHash"`
+	Hash     string `json:"hash"`
+}
+
+// AuditLog is the body of GET /api/v1/audit
+type AuditLog struct {
+	Entries []AuditEntry `json:"entries"`
+}
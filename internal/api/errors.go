@@ -0,0 +1,58 @@
+// Package api holds the types shared by the server and client
+// to describe requests, responses and errors on the wire.
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// RequestIDHeader carries the id of a request. clients may set it,
+// otherwise the server assigns one. it is echoed on every response.
+const RequestIDHeader = "X-Request-ID"
+
+// error codes. these are stable and safe for clients to match on.
+const (
+	CodeInvalidRequest    = "invalid_request"
+	CodeInvalidCommand    = "invalid_command"
+	CodeInvalidParams     = "invalid_params"
+	CodeJobNotFound       = "job_not_found"
+	CodeJobActive         = "job_active"
+	CodeNamespaceNotFound = "namespace_not_found"
+	CodeTemplateNotFound  = "template_not_found"
+	CodeTemplateExists    = "template_exists"
+	CodeSecretNotFound    = "secret_not_found"
+	CodeArtifactNotFound  = "artifact_not_found"
+	CodeNotFound          = "not_found"
+	CodeMethodNotAllowed  = "method_not_allowed"
+	CodeForbidden         = "forbidden"
+	CodeQuotaExceeded     = "quota_exceeded"
+	CodeRateLimited       = "rate_limited"
+	CodeUnavailable       = "unavailable"
+	CodeInternal          = "internal"
+)
+
+// Error describes why a request failed
+type Error struct {
+	// Status is the http status code. it isn't part of the body.
+	Status    int               `json:"-"`
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	RequestID string            `json:"requestId,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	// RetryAfter is sent in the Retry-After header of 429 responses.
+	// it isn't part of the body.
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+}
+
+// ErrorResponse is the body of every failed request
+type ErrorResponse struct {
+	Error *Error `json:"error"`
+}
@@ -0,0 +1,129 @@
+package api
+
+import "time"
+
+// every field of a v1 response is always present. fields with nothing
+// to report are null, false, 0 or empty rather than left out, so
+// clients can tell "not set" from a field they don't know about.
+// request fields tagged omitempty are optional.
+
+// Job is a job resource
+type Job struct {
+	// ID is unique within Namespace
+	ID        string            `json:"id"`
+	Namespace string            `json:"namespace"`
+	Cmd       []string          `json:"cmd"`
+	Owner     string            `json:"owner"`
+	Labels    map[string]string `json:"labels"`
+	Status    string            `json:"status"`
+	// ExitCode is null until the job has ended
+	ExitCode *int      `json:"exitCode"`
+	Created  time.Time `json:"created"`
+	// Started and Ended are null until the job gets there
+	Started *time.Time `json:"started"`
+	Ended   *time.Time `json:"ended"`
+	// Redactions counts the matches of redaction rules and secret
+	// values hidden in the output
+	Redactions int `json:"redactions"`
+}
+
+// JobList is the body of GET /api/v1/jobs
+type JobList struct {
+	Jobs []Job `json:"jobs"`
+}
+
+// StartJobRequest is the body of POST /api/v1/jobs
+type StartJobRequest struct {
+	Cmd    []string          `json:"cmd"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Webhooks are http(s) urls POSTed to when the job ends
+	Webhooks []string `json:"webhooks,omitempty"`
+	// Secrets are given to the job in its environment
+	Secrets []SecretRef `json:"secrets,omitempty"`
+	// Dir is the absolute working directory of the command. defaults
+	// to the server's
+	Dir string `json:"dir,omitempty"`
+	// Artifacts are globs relative to Dir of files kept once the job
+	// ends
+	Artifacts []string `json:"artifacts,omitempty"`
+}
+
+// StopJobResponse is the body of DELETE /api/v1/jobs/:id.
+// Stopped is false if the job wasn't running.
+type StopJobResponse struct {
+	ID      string `json:"id"`
+	Stopped bool   `json:"stopped"`
+}
+
+// JobLog is the full output of a job
+type JobLog struct {
+	ID     string   `json:"id"`
+	Cmd    []string `json:"cmd"`
+	Status string   `json:"status"`
+	Output string   `json:"output"`
+}
+
+// JobOutput is one long-poll of a job's output. Offset is what to
+// pass as ?offset= on the next poll.
+type JobOutput struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Output   string `json:"output"`
+	Offset   int    `json:"offset"`
+	ExitCode *int   `json:"exitCode"`
+}
+
+// WebhookDelivery is one attempt to call a webhook
+type WebhookDelivery struct {
+	URL        string    `json:"url"`
+	Attempt    int       `json:"attempt"`
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"statusCode"`
+	Error      string    `json:"error"`
+	Success    bool      `json:"success"`
+}
+
+// WebhookDeliveryList is the webhook delivery log of a job
+type WebhookDeliveryList struct {
+	ID         string            `json:"id"`
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// PurgeJobResponse is the body of POST /api/v1/jobs/:id/purge
+type PurgeJobResponse struct {
+	ID     string `json:"id"`
+	Purged bool   `json:"purged"`
+}
+
+// PruneRequest is a retention policy to prune with. an empty body
+// uses the server's own policy.
+type PruneRequest struct {
+	// MaxAge is a duration such as "24h"
+	MaxAge      string `json:"maxAge,omitempty"`
+	MaxCount    int    `json:"maxCount,omitempty"`
+	MaxLogBytes int    `json:"maxLogBytes,omitempty"`
+}
+
+// PruneResponse lists the ids of the jobs removed by a prune
+type PruneResponse struct {
+	Removed []string `json:"removed"`
+}
+
+// Event is a change in a job's lifecycle, sent as the data of a
+// server-sent event. ID is also the event id used to resume the stream.
+type Event struct {
+	ID        uint64            `json:"id"`
+	Type      string            `json:"type"`
+	Time      time.Time         `json:"time"`
+	JobID     string            `json:"jobId"`
+	Namespace string            `json:"namespace"`
+	Owner     string            `json:"owner"`
+	Labels    map[string]string `json:"labels"`
+	Status    string            `json:"status"`
+	Output    string            `json:"output"`
+	ExitCode  *int              `json:"exitCode"`
+	// Dropped lists the kinds of events, "lifecycle" or "output", lost
+	// to a gap event. they were published after the cursor but dropped
+	// out of the server's buffer before they could be sent.
+	Dropped []string `json:"dropped,omitempty"`
+}
@@ -0,0 +1,5 @@
+// Package ljwpb holds the protobuf messages and gRPC service generated
+// from ljw.proto. run go generate after editing it.
+package ljwpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative ljw.proto
@@ -0,0 +1,356 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: ljw.proto
+
+package ljwpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// JobServiceClient is the client API for JobService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type JobServiceClient interface {
+	// Start starts a job owned by the caller
+	Start(ctx context.Context, in *StartRequest, opts ...grpc.CallOption) (*Job, error)
+	// Stop stops a job if it is running
+	Stop(ctx context.Context, in *StopRequest, opts ...grpc.CallOption) (*StopResponse, error)
+	// Get returns a job
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Job, error)
+	// List returns all jobs in id order
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	// StreamLogs streams the output of a job from an offset. with follow
+	// set it keeps streaming until the job ends.
+	StreamLogs(ctx context.Context, in *StreamLogsRequest, opts ...grpc.CallOption) (JobService_StreamLogsClient, error)
+	// WatchStatus streams status changes of matching jobs until the
+	// client cancels
+	WatchStatus(ctx context.Context, in *WatchStatusRequest, opts ...grpc.CallOption) (JobService_WatchStatusClient, error)
+}
+
+type jobServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewJobServiceClient(cc grpc.ClientConnInterface) JobServiceClient {
+	return &jobServiceClient{cc}
+}
+
+func (c *jobServiceClient) Start(ctx context.Context, in *StartRequest, opts ...grpc.CallOption) (*Job, error) {
+	out := new(Job)
+	err := c.cc.Invoke(ctx, "/ljw.v1.JobService/Start", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) Stop(ctx context.Context, in *StopRequest, opts ...grpc.CallOption) (*StopResponse, error) {
+	out := new(StopResponse)
+	err := c.cc.Invoke(ctx, "/ljw.v1.JobService/Stop", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Job, error) {
+	out := new(Job)
+	err := c.cc.Invoke(ctx, "/ljw.v1.JobService/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, "/ljw.v1.JobService/List", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) StreamLogs(ctx context.Context, in *StreamLogsRequest, opts ...grpc.CallOption) (JobService_StreamLogsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &JobService_ServiceDesc.Streams[0], "/ljw.v1.JobService/StreamLogs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &jobServiceStreamLogsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type JobService_StreamLogsClient interface {
+	Recv() (*LogChunk, error)
+	grpc.ClientStream
+}
+
+type jobServiceStreamLogsClient struct {
+	grpc.ClientStream
+}
+
+func (x *jobServiceStreamLogsClient) Recv() (*LogChunk, error) {
+	m := new(LogChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *jobServiceClient) WatchStatus(ctx context.Context, in *WatchStatusRequest, opts ...grpc.CallOption) (JobService_WatchStatusClient, error) {
+	stream, err := c.cc.NewStream(ctx, &JobService_ServiceDesc.Streams[1], "/ljw.v1.JobService/WatchStatus", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &jobServiceWatchStatusClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type JobService_WatchStatusClient interface {
+	Recv() (*StatusEvent, error)
+	grpc.ClientStream
+}
+
+type jobServiceWatchStatusClient struct {
+	grpc.ClientStream
+}
+
+func (x *jobServiceWatchStatusClient) Recv() (*StatusEvent, error) {
+	m := new(StatusEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// JobServiceServer is the server API for JobService service.
+// All implementations must embed UnimplementedJobServiceServer
+// for forward compatibility
+type JobServiceServer interface {
+	// Start starts a job owned by the caller
+	Start(context.Context, *StartRequest) (*Job, error)
+	// Stop stops a job if it is running
+	Stop(context.Context, *StopRequest) (*StopResponse, error)
+	// Get returns a job
+	Get(context.Context, *GetRequest) (*Job, error)
+	// List returns all jobs in id order
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	// StreamLogs streams the output of a job from an offset. with follow
+	// set it keeps streaming until the job ends.
+	StreamLogs(*StreamLogsRequest, JobService_StreamLogsServer) error
+	// WatchStatus streams status changes of matching jobs until the
+	// client cancels
+	WatchStatus(*WatchStatusRequest, JobService_WatchStatusServer) error
+	mustEmbedUnimplementedJobServiceServer()
+}
+
+// UnimplementedJobServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedJobServiceServer struct {
+}
+
+func (UnimplementedJobServiceServer) Start(context.Context, *StartRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Start not implemented")
+}
+func (UnimplementedJobServiceServer) Stop(context.Context, *StopRequest) (*StopResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stop not implemented")
+}
+func (UnimplementedJobServiceServer) Get(context.Context, *GetRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedJobServiceServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedJobServiceServer) StreamLogs(*StreamLogsRequest, JobService_StreamLogsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamLogs not implemented")
+}
+func (UnimplementedJobServiceServer) WatchStatus(*WatchStatusRequest, JobService_WatchStatusServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchStatus not implemented")
+}
+func (UnimplementedJobServiceServer) mustEmbedUnimplementedJobServiceServer() {}
+
+// UnsafeJobServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to JobServiceServer will
+// result in compilation errors.
+type UnsafeJobServiceServer interface {
+	mustEmbedUnimplementedJobServiceServer()
+}
+
+func RegisterJobServiceServer(s grpc.ServiceRegistrar, srv JobServiceServer) {
+	s.RegisterService(&JobService_ServiceDesc, srv)
+}
+
+func _JobService_Start_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).Start(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ljw.v1.JobService/Start",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).Start(ctx, req.(*StartRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_Stop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StopRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).Stop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ljw.v1.JobService/Stop",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).Stop(ctx, req.(*StopRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ljw.v1.JobService/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ljw.v1.JobService/List",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_StreamLogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamLogsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(JobServiceServer).StreamLogs(m, &jobServiceStreamLogsServer{stream})
+}
+
+type JobService_StreamLogsServer interface {
+	Send(*LogChunk) error
+	grpc.ServerStream
+}
+
+type jobServiceStreamLogsServer struct {
+	grpc.ServerStream
+}
+
+func (x *jobServiceStreamLogsServer) Send(m *LogChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _JobService_WatchStatus_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchStatusRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(JobServiceServer).WatchStatus(m, &jobServiceWatchStatusServer{stream})
+}
+
+type JobService_WatchStatusServer interface {
+	Send(*StatusEvent) error
+	grpc.ServerStream
+}
+
+type jobServiceWatchStatusServer struct {
+	grpc.ServerStream
+}
+
+func (x *jobServiceWatchStatusServer) Send(m *StatusEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// JobService_ServiceDesc is the grpc.ServiceDesc for JobService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var JobService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ljw.v1.JobService",
+	HandlerType: (*JobServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Start",
+			Handler:    _JobService_Start_Handler,
+		},
+		{
+			MethodName: "Stop",
+			Handler:    _JobService_Stop_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _JobService_Get_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _JobService_List_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamLogs",
+			Handler:       _JobService_StreamLogs_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchStatus",
+			Handler:       _JobService_WatchStatus_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "ljw.proto",
+}
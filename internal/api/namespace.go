@@ -0,0 +1,25 @@
+package api
+
+// DefaultNamespace holds the jobs started outside /api/v1/namespaces
+const DefaultNamespace = "default"
+
+// Namespace is a namespace the caller can access
+type Namespace struct {
+	Name string `json:"name"`
+	// Access is "read" or "write"
+	Access string    `json:"access"`
+	Limits JobLimits `json:"limits"`
+}
+
+// JobLimits are the resource limits of a job. zero fields are not enforced.
+type JobLimits struct {
+	// Timeout is a duration such as "1h", or "" for none
+	Timeout     string  `json:"timeout"`
+	CPUSeconds  float64 `json:"cpuSeconds"`
+	MemoryBytes int64   `json:"memoryBytes"`
+}
+
+// NamespaceList is the body of GET /api/v1/namespaces
+type NamespaceList struct {
+	Namespaces []Namespace `json:"namespaces"`
+}
@@ -0,0 +1,413 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// V1 is the path prefix of version 1 of the API
+const V1 = "/api/v1"
+
+// Param is a path, query or header parameter of a route
+type Param struct {
+	Name        string
+	In          string
+	Description string
+	// Type is the OpenAPI type of the value. defaults to string
+	Type string
+}
+
+// Route describes one v1 endpoint. the server registers a handler for
+// every route and the OpenAPI document is generated from the same list.
+type Route struct {
+	Method string
+	// Path is in httprouter form, e.g. /api/v1/jobs/:id
+	Path string
+	// Operation names the handler and is the OpenAPI operationId
+	Operation string
+	Summary   string
+	Params    []Param
+	// Request is a value of the request body type, or nil
+	Request interface{}
+	// Response is a value of the response body type
+	Response interface{}
+	// Status is the status code of a successful response
+	Status int
+	// Stream marks routes that answer with server-sent events
+	Stream bool
+	// File marks routes that answer with the bytes of a file. Response
+	// is nil
+	File bool
+	// Namespaced marks the copies of job routes under /namespaces/:ns.
+	// they share the handler of the route they copy
+	Namespaced bool
+}
+
+// OperationID is the OpenAPI operationId of the route
+func (rt Route) OperationID() string {
+	if rt.Namespaced {
+		return rt.Operation + "InNamespace"
+	}
+	return rt.Operation
+}
+
+var (
+	idParam      = Param{Name: "id", In: "path", Description: "job id"}
+	nsParam      = Param{Name: "ns", In: "path", Description: "namespace"}
+	nameParam    = Param{Name: "name", In: "path", Description: "template name"}
+	secretParam  = Param{Name: "name", In: "path", Description: "secret name"}
+	pathParam    = Param{Name: "path", In: "path", Description: "artifact path, e.g. reports/junit.xml"}
+	timeoutParam = Param{Name: "timeout", In: "query", Description: "long-poll timeout as a duration. default 20s, max 25s"}
+)
+
+// Routes are the endpoints of the v1 API. the routes of jobs, templates,
+// events and quotas act in the default namespace and are repeated under
+// /namespaces/:ns for the others. listing jobs and streaming events
+// outside /namespaces spans every namespace the caller can read.
+var Routes = append(routes, namespaced(routes)...)
+
+var routes = []Route{
+	{
+		Method: http.MethodGet, Path: V1 + "/jobs", Operation: "listJobs",
+		Summary:  "List jobs",
+		Response: JobList{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodPost, Path: V1 + "/jobs", Operation: "startJob",
+		Summary: "Start a job",
+		Request: StartJobRequest{}, Response: Job{}, Status: http.StatusCreated,
+	},
+	{
+		Method: http.MethodGet, Path: V1 + "/jobs/:id", Operation: "getJob",
+		Summary: "Get a job",
+		Params:  []Param{idParam}, Response: Job{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodDelete, Path: V1 + "/jobs/:id", Operation: "stopJob",
+		Summary: "Stop a running job",
+		Params:  []Param{idParam}, Response: StopJobResponse{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodGet, Path: V1 + "/jobs/:id/log", Operation: "getJobLog",
+		Summary: "Get all output of a job",
+		Params:  []Param{idParam}, Response: JobLog{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodGet, Path: V1 + "/jobs/:id/output", Operation: "followJob",
+		Summary: "Long-poll for output written since an offset",
+		Params: []Param{
+			idParam,
+			{Name: "offset", In: "query", Description: "offset returned by the previous poll", Type: "integer"},
+			timeoutParam,
+		},
+		Response: JobOutput{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodGet, Path: V1 + "/jobs/:id/wait", Operation: "waitJob",
+		Summary: "Long-poll until a job ends",
+		Params:  []Param{idParam, timeoutParam}, Response: Job{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodGet, Path: V1 + "/jobs/:id/webhooks", Operation: "jobWebhooks",
+		Summary: "Get the webhook delivery log of a job",
+		Params:  []Param{idParam}, Response: WebhookDeliveryList{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodGet, Path: V1 + "/jobs/:id/artifacts", Operation: "listArtifacts",
+		Summary: "List the artifacts kept from a job",
+		Params:  []Param{idParam}, Response: ArtifactList{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodGet, Path: V1 + "/jobs/:id/artifacts/*path", Operation: "getArtifact",
+		Summary: "Download an artifact of a job",
+		Params:  []Param{idParam, pathParam}, Status: http.StatusOK, File: true,
+	},
+	{
+		Method: http.MethodPost, Path: V1 + "/jobs/:id/purge", Operation: "purgeJob",
+		Summary: "Remove a job that has ended",
+		Params:  []Param{idParam}, Response: PurgeJobResponse{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodPost, Path: V1 + "/prune", Operation: "pruneJobs",
+		Summary: "Remove ended jobs outside a retention policy",
+		Request: PruneRequest{}, Response: PruneResponse{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodGet, Path: V1 + "/events", Operation: "streamEvents",
+		Summary: "Stream job events",
+		Params: []Param{
+			{Name: "job", In: "query", Description: "only events of this job id"},
+			{Name: "owner", In: "query", Description: "only events of jobs owned by this identity"},
+			{Name: "label", In: "query", Description: "only events of jobs with this key=value label. repeatable"},
+			{Name: "cursor", In: "query", Description: "resume after this event id"},
+			{Name: "Last-Event-ID", In: "header", Description: "resume after this event id. overrides cursor"},
+			timeoutParam,
+		},
+		Response: Event{}, Status: http.StatusOK, Stream: true,
+	},
+	{
+		Method: http.MethodGet, Path: V1 + "/audit", Operation: "readAudit",
+		Summary: "Read the audit log. admins only",
+		Params: []Param{
+			{Name: "identity", In: "query", Description: "only actions by this identity"},
+			{Name: "action", In: "query", Description: "only this action, e.g. startJob"},
+			{Name: "job", In: "query", Description: "only actions on this job id"},
+			{Name: "since", In: "query", Description: "only actions at or after this RFC 3339 time"},
+			{Name: "limit", In: "query", Description: "newest entries to return. default 100, max 1000", Type: "integer"},
+		},
+		Response: AuditLog{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodGet, Path: V1 + "/quota", Operation: "getQuota",
+		Summary: "Get the caller's limits and usage",
+		Params: []Param{
+			{Name: "identity", In: "query", Description: "get the quota of this identity instead. admins only"},
+		},
+		Response: Quota{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodGet, Path: V1 + "/templates", Operation: "listTemplates",
+		Summary:  "List job templates",
+		Response: TemplateList{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodPost, Path: V1 + "/templates", Operation: "createTemplate",
+		Summary: "Create a job template",
+		Request: CreateTemplateRequest{}, Response: Template{}, Status: http.StatusCreated,
+	},
+	{
+		Method: http.MethodGet, Path: V1 + "/templates/:name", Operation: "getTemplate",
+		Summary: "Get a job template",
+		Params:  []Param{nameParam}, Response: Template{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodDelete, Path: V1 + "/templates/:name", Operation: "deleteTemplate",
+		Summary: "Delete a job template",
+		Params:  []Param{nameParam}, Response: DeleteTemplateResponse{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodPost, Path: V1 + "/templates/:name/start", Operation: "startTemplate",
+		Summary: "Start a job from a template",
+		Params:  []Param{nameParam}, Request: StartTemplateRequest{}, Response: Job{}, Status: http.StatusCreated,
+	},
+	{
+		Method: http.MethodGet, Path: V1 + "/secrets", Operation: "listSecrets",
+		Summary:  "List secrets without their values",
+		Response: SecretList{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodPut, Path: V1 + "/secrets/:name", Operation: "setSecret",
+		Summary: "Create or replace a secret",
+		Params:  []Param{secretParam}, Request: SetSecretRequest{}, Response: Secret{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodDelete, Path: V1 + "/secrets/:name", Operation: "deleteSecret",
+		Summary: "Delete a secret",
+		Params:  []Param{secretParam}, Response: DeleteSecretResponse{}, Status: http.StatusOK,
+	},
+	{
+		Method: http.MethodGet, Path: V1 + "/namespaces", Operation: "listNamespaces",
+		Summary:  "List the namespaces the caller can access",
+		Response: NamespaceList{}, Status: http.StatusOK,
+	},
+}
+
+// namespaced returns the routes of rts that act in a namespace, moved
+// under /namespaces/:ns
+func namespaced(rts []Route) []Route {
+	var list []Route
+	for _, rt := range rts {
+		p := strings.TrimPrefix(rt.Path, V1)
+		if !strings.HasPrefix(p, "/jobs") && !strings.HasPrefix(p, "/templates") && !strings.HasPrefix(p, "/secrets") && p != "/events" && p != "/quota" {
+			continue
+		}
+		rt.Path = V1 + "/namespaces/:ns" + p
+		rt.Summary += " in a namespace"
+		rt.Params = append([]Param{nsParam}, rt.Params...)
+		rt.Namespaced = true
+		list = append(list, rt)
+	}
+	return list
+}
+
+// OpenAPI returns the OpenAPI 3 document describing Routes. it is
+// built from the request and response types so it can't drift from them.
+func OpenAPI(version string) map[string]interface{} {
+	g := &schemaGen{schemas: make(map[string]interface{})}
+	errorRef := g.schema(reflect.TypeOf(ErrorResponse{}))
+
+	paths := make(map[string]interface{})
+	for _, rt := range Routes {
+		path := openAPIPath(rt.Path)
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+
+		contentType := "application/json"
+		var schema interface{}
+		switch {
+		case rt.Stream:
+			contentType = "text/event-stream"
+		case rt.File:
+			contentType = "application/octet-stream"
+			schema = map[string]interface{}{"type": "string", "format": "binary"}
+		}
+		if schema == nil {
+			schema = g.schema(reflect.TypeOf(rt.Response))
+		}
+		op := map[string]interface{}{
+			"operationId": rt.OperationID(),
+			"summary":     rt.Summary,
+			"responses": map[string]interface{}{
+				strconv.Itoa(rt.Status): map[string]interface{}{
+					"description": http.StatusText(rt.Status),
+					"content": map[string]interface{}{
+						contentType: map[string]interface{}{"schema": schema},
+					},
+				},
+				"default": map[string]interface{}{
+					"description": "error",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": errorRef},
+					},
+				},
+			},
+		}
+
+		if len(rt.Params) > 0 {
+			var params []interface{}
+			for _, p := range rt.Params {
+				typ := p.Type
+				if typ == "" {
+					typ = "string"
+				}
+				params = append(params, map[string]interface{}{
+					"name":        p.Name,
+					"in":          p.In,
+					"description": p.Description,
+					"required":    p.In == "path",
+					"schema":      map[string]interface{}{"type": typ},
+				})
+			}
+			op["parameters"] = params
+		}
+
+		if rt.Request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(rt.Request))},
+				},
+			}
+		}
+
+		item[strings.ToLower(rt.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "ljw",
+			"version": version,
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": g.schemas},
+	}
+}
+
+// openAPIPath turns an httprouter path into an OpenAPI one
+func openAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaGen builds JSON schemas for types. structs become named
+// schemas under components and are referenced from everywhere else.
+type schemaGen struct {
+	schemas map[string]interface{}
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		s := g.schema(t.Elem())
+		if _, ok := s["$ref"]; ok {
+			return map[string]interface{}{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Int32, reflect.Uint64, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float64, reflect.Float32:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := g.schemas[t.Name()]; ok {
+			return ref
+		}
+		// placeholder so self references terminate
+		g.schemas[t.Name()] = nil
+		g.schemas[t.Name()] = g.object(t)
+		return ref
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// object builds the schema of struct t from its json tags.
+// fields without omitempty are required.
+func (g *schemaGen) object(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || f.PkgPath != "" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		if name == "" {
+			name = f.Name
+		}
+
+		props[name] = g.schema(f.Type)
+		omitempty := false
+		for _, o := range opts[1:] {
+			omitempty = omitempty || o == "omitempty"
+		}
+		if !omitempty {
+			required = append(required, name)
+		}
+	}
+
+	s := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
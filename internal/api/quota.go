@@ -0,0 +1,36 @@
+package api
+
+import "time"
+
+// Quota is the body of GET /api/v1/quota. jobs are counted in
+// Namespace only
+type Quota struct {
+	Identity  string      `json:"identity"`
+	Namespace string      `json:"namespace"`
+	Limits    QuotaLimits `json:"limits"`
+	Usage     QuotaUsage  `json:"usage"`
+}
+
+// QuotaLimits are the limits of an identity. zero fields are not
+// enforced, except maxQueued: with it zero, jobs over maxRunning are
+// turned away instead of queued.
+type QuotaLimits struct {
+	// Rate is the API calls allowed per second on average
+	Rate             float64 `json:"rate"`
+	Burst            int     `json:"burst"`
+	MaxRunning       int     `json:"maxRunning"`
+	MaxQueued        int     `json:"maxQueued"`
+	CPUSecondsPerDay float64 `json:"cpuSecondsPerDay"`
+}
+
+// QuotaUsage is how much of its limits an identity is using
+type QuotaUsage struct {
+	// RateRemaining is the calls that can be made right now. it is -1
+	// without a rate limit
+	RateRemaining int `json:"rateRemaining"`
+	Running       int `json:"running"`
+	Queued        int `json:"queued"`
+	// CPUSeconds is the cpu time used by jobs that ended today (UTC)
+	CPUSeconds float64   `json:"cpuSeconds"`
+	CPUReset   time.Time `json:"cpuReset"`
+}
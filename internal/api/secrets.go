@@ -0,0 +1,37 @@
+package api
+
+import "time"
+
+// Secret describes a stored secret. its value is never returned.
+type Secret struct {
+	Name      string    `json:"name"`
+	Namespace string    `json:"namespace"`
+	Owner     string    `json:"owner"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+}
+
+// SetSecretRequest is the body of PUT /api/v1/secrets/:name
+type SetSecretRequest struct {
+	Value string `json:"value"`
+}
+
+// SecretList is the body of GET /api/v1/secrets
+type SecretList struct {
+	Secrets []Secret `json:"secrets"`
+}
+
+// DeleteSecretResponse is the body of DELETE /api/v1/secrets/:name
+type DeleteSecretResponse struct {
+	Name    string `json:"name"`
+	Deleted bool   `json:"deleted"`
+}
+
+// SecretRef gives the value of a secret to a job in an environment
+// variable. with File set the value is written to a temp file that is
+// removed when the job ends and the variable holds its path.
+type SecretRef struct {
+	Name string `json:"name"`
+	Env  string `json:"env"`
+	File bool   `json:"file,omitempty"`
+}
@@ -0,0 +1,59 @@
+package api
+
+import "time"
+
+// Template is a named command for jobs that are run often. args of Cmd
+// and values of Env may hold {{param}} placeholders that are filled in
+// when a job is started from it.
+type Template struct {
+	Name      string                   `json:"name"`
+	Namespace string                   `json:"namespace"`
+	Cmd       []string                 `json:"cmd"`
+	Params    map[string]TemplateParam `json:"params"`
+	Env       map[string]string        `json:"env"`
+	Labels    map[string]string        `json:"labels"`
+	// Limits are tightened by the limits of the namespace
+	Limits  JobLimits `json:"limits"`
+	Owner   string    `json:"owner"`
+	Created time.Time `json:"created"`
+}
+
+// TemplateParam is a parameter of a template
+type TemplateParam struct {
+	Description string `json:"description,omitempty"`
+	// Default is used when the parameter isn't given. without one the
+	// parameter is required
+	Default *string `json:"default,omitempty"`
+	// Pattern is a regular expression the whole value must match
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// CreateTemplateRequest is the body of POST /api/v1/templates
+type CreateTemplateRequest struct {
+	Name   string                   `json:"name"`
+	Cmd    []string                 `json:"cmd"`
+	Params map[string]TemplateParam `json:"params,omitempty"`
+	Env    map[string]string        `json:"env,omitempty"`
+	Labels map[string]string        `json:"labels,omitempty"`
+	Limits *JobLimits               `json:"limits,omitempty"`
+}
+
+// TemplateList is the body of GET /api/v1/templates
+type TemplateList struct {
+	Templates []Template `json:"templates"`
+}
+
+// StartTemplateRequest is the body of POST /api/v1/templates/:name/start
+type StartTemplateRequest struct {
+	Params map[string]string `json:"params,omitempty"`
+	// Labels are added to the labels of the template
+	Labels   map[string]string `json:"labels,omitempty"`
+	Webhooks []string          `json:"webhooks,omitempty"`
+	Secrets  []SecretRef       `json:"secrets,omitempty"`
+}
+
+// DeleteTemplateResponse is the body of DELETE /api/v1/templates/:name
+type DeleteTemplateResponse struct {
+	Name    string `json:"name"`
+	Deleted bool   `json:"deleted"`
+}
@@ -0,0 +1,213 @@
+// Package audit keeps an append-only log of API actions as JSON lines.
+// entries can be hash chained: each one then holds the hash of the
+// entry before it, so editing or dropping a line breaks the chain.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// outcomes
+const (
+	OK    = "ok"
+	Error = "error"
+)
+
+// Entry records one API action
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Identity   string    `json:"identity"`
+	RemoteAddr string    `json:"remoteAddr"`
+	RequestID  string    `json:"requestId"`
+	Action     string    `json:"action"`
+	JobID      string    `json:"jobId,omitempty"`
+	Cmd        []string  `json:"cmd,omitempty"`
+	// Status is the http status code of the response
+	Status  int    `json:"status"`
+	Outcome string `json:"outcome"`
+	// Error is the api error code of a failed action
+	Error string `json:"error,omitempty"`
+	// PrevHash and Hash are only set when the log is chained
+	PrevHash string `json:"prevHash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// Filter selects entries. empty fields match everything.
+type Filter struct {
+	Identity string
+	Action   string
+	JobID    string
+	Since    time.Time
+}
+
+func (f Filter) match(e Entry) bool {
+	return (f.Identity == "" || f.Identity == e.Identity) &&
+		(f.Action == "" || f.Action == e.Action) &&
+		(f.JobID == "" || f.JobID == e.JobID) &&
+		!e.Time.Before(f.Since)
+}
+
+// Log appends entries to a file
+type Log struct {
+	file  string
+	f     *os.File
+	chain bool
+	// last is the hash of the newest entry when chained
+	last string
+	sync.Mutex
+}
+
+// Open opens the log in file for appending, creating it if needed.
+// if chain is set, new entries continue the hash chain of the file.
+func Open(file string, chain bool) (*Log, error) {
+	l := &Log{file: file, chain: chain}
+
+	if chain {
+		last, err := lastHash(file)
+		if err != nil {
+			return nil, err
+		}
+		l.last = last
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l.f = f
+	return l, nil
+}
+
+// Write appends e to the log
+func (l *Log) Write(e Entry) error {
+	l.Lock()
+	defer l.Unlock()
+
+	e.PrevHash, e.Hash = "", ""
+	if l.chain {
+		e.PrevHash = l.last
+		h, err := hash(e)
+		if err != nil {
+			return err
+		}
+		e.Hash = h
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	l.last = e.Hash
+	return nil
+}
+
+// Read returns the newest entries matching filter, oldest first.
+// at most limit entries are returned.
+func (l *Log) Read(filter Filter, limit int) ([]Entry, error) {
+	var entries []Entry
+	err := scan(l.file, func(_ int, e Entry) error {
+		if !filter.match(e) {
+			return nil
+		}
+		entries = append(entries, e)
+		if len(entries) > limit {
+			entries = entries[1:]
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	return l.f.Close()
+}
+
+// Verify checks the hash chain of the log in file. it returns an error
+// naming the first line that doesn't follow from the one before it.
+func Verify(file string) error {
+	prev := ""
+	return scan(file, func(n int, e Entry) error {
+		if e.PrevHash != prev {
+			return fmt.Errorf("line %d: chain broken. expected previous hash %q, got %q", n, prev, e.PrevHash)
+		}
+		want := e.Hash
+		h, err := hash(e)
+		if err != nil {
+			return err
+		}
+		if h != want {
+			return fmt.Errorf("line %d: hash mismatch. entry was modified", n)
+		}
+		prev = want
+		return nil
+	})
+}
+
+// hash returns the hex SHA-256 of e with its Hash field cleared
+func hash(e Entry) (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// lastHash returns the hash of the last entry in file, or "" if the
+// file doesn't exist or is empty
+func lastHash(file string) (string, error) {
+	last := ""
+	err := scan(file, func(_ int, e Entry) error {
+		last = e.Hash
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return last, err
+}
+
+// scan calls fn with every entry in file and its line number
+func scan(file string, fn func(int, Entry) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		// a line without a newline is still being written
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		if err := fn(n, e); err != nil {
+			return err
+		}
+	}
+}
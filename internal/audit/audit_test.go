@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(file, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer l.Close()
+
+	start := time.Now().UTC()
+	for _, e := range []Entry{
+		{Time: start, Identity: "alice", Action: "startJob", JobID: "1", Cmd: []string{"sleep", "1"}, Status: 201, Outcome: OK},
+		{Time: start.Add(time.Second), Identity: "bob", Action: "stopJob", JobID: "1", Status: 200, Outcome: OK},
+		{Time: start.Add(2 * time.Second), Identity: "bob", Action: "getJob", JobID: "9", Status: 404, Outcome: Error, Error: "job_not_found"},
+	} {
+		if err := l.Write(e); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	t.Run("test read filters and keeps the newest entries", func(t *testing.T) {
+		actions := func(filter Filter, limit int) []string {
+			entries, err := l.Read(filter, limit)
+			assert.NoError(t, err)
+			var list []string
+			for _, e := range entries {
+				list = append(list, e.Action)
+			}
+			return list
+		}
+
+		assert.Equal(t, []string{"startJob", "stopJob", "getJob"}, actions(Filter{}, 10))
+		assert.Equal(t, []string{"stopJob", "getJob"}, actions(Filter{}, 2))
+		assert.Equal(t, []string{"stopJob", "getJob"}, actions(Filter{Identity: "bob"}, 10))
+		assert.Equal(t, []string{"startJob", "stopJob"}, actions(Filter{JobID: "1"}, 10))
+		assert.Equal(t, []string{"getJob"}, actions(Filter{Since: start.Add(2 * time.Second)}, 10))
+		assert.Nil(t, actions(Filter{Action: "purgeJob"}, 10))
+	})
+
+	t.Run("test entries are chained", func(t *testing.T) {
+		entries, _ := l.Read(Filter{}, 10)
+		assert.Empty(t, entries[0].PrevHash)
+		for i := 1; i < len(entries); i++ {
+			assert.NotEmpty(t, entries[i].Hash)
+			assert.Equal(t, entries[i-1].Hash, entries[i].PrevHash)
+		}
+		assert.NoError(t, Verify(file))
+	})
+
+	t.Run("test reopening continues the chain", func(t *testing.T) {
+		reopened, err := Open(file, true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer reopened.Close()
+		assert.NoError(t, reopened.Write(Entry{Time: time.Now().UTC(), Identity: "carol", Action: "listJobs", Outcome: OK}))
+		assert.NoError(t, Verify(file))
+	})
+
+	t.Run("test hash chain detects changes", func(t *testing.T) {
+		data, _ := ioutil.ReadFile(file)
+
+		ioutil.WriteFile(file, bytes.Replace(data, []byte(`"identity":"bob"`), []byte(`"identity":"eve"`), 1), 0600)
+		assert.EqualError(t, Verify(file), "line 2: hash mismatch. entry was modified")
+
+		lines := strings.SplitAfter(string(data), "\n")
+		ioutil.WriteFile(file, []byte(lines[0]+strings.Join(lines[2:], "")), 0600)
+		if err := Verify(file); assert.Error(t, err, "dropped lines break the chain") {
+			assert.Contains(t, err.Error(), "line 2: chain broken")
+		}
+	})
+}
+
+func TestUnchained(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(file, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer l.Close()
+
+	assert.NoError(t, l.Write(Entry{Identity: "alice", Action: "listJobs", Outcome: OK, Hash: "forged"}))
+	entries, err := l.Read(Filter{}, 10)
+	if assert.NoError(t, err) && assert.Len(t, entries, 1) {
+		assert.Empty(t, entries[0].Hash)
+		assert.Empty(t, entries[0].PrevHash)
+	}
+}
@@ -0,0 +1,213 @@
+// Package certs loads a TLS certificate, its key and a CA bundle from
+// files and reloads them when the files change, so long running servers
+// and clients pick up renewed certificates without a restart.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCheckInterval is how often the files are checked for changes
+const DefaultCheckInterval = 10 * time.Second
+
+// Store holds the current certificate and CA pool. it is safe for
+// concurrent use and is meant to back tls.Config callbacks.
+type Store struct {
+	certFile, keyFile, caFile string
+	interval                  time.Duration
+
+	// cur is the current *bundle. it is swapped whole on reload so
+	// handshakes never see a certificate with the wrong key or CA
+	cur atomic.Value
+	// checked is when the files were last checked, in unix nanoseconds
+	checked int64
+	// mu serializes reloads
+	mu sync.Mutex
+}
+
+// bundle is everything loaded from the files at one point in time
+type bundle struct {
+	cert *tls.Certificate
+	leaf *x509.Certificate
+	pool *x509.CertPool
+	// ca is the first certificate in the CA file
+	ca *x509.Certificate
+	// modTimes of the cert, key and CA files when they were loaded
+	modTimes [3]time.Time
+}
+
+// Load reads the certificate, key and CA files. they are checked for
+// changes at most once every interval, when the store is next used.
+func Load(certFile, keyFile, caFile string, interval time.Duration) (*Store, error) {
+	s := &Store{certFile: certFile, keyFile: keyFile, caFile: caFile, interval: interval}
+
+	b, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.cur.Store(b)
+	atomic.StoreInt64(&s.checked, time.Now().UnixNano())
+	return s, nil
+}
+
+// Reload reads the files again whether or not they changed. if they
+// can't be loaded the current certificate and CA are kept.
+func (s *Store) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reload()
+}
+
+// reload loads the files and swaps them in. the caller holds s.mu.
+func (s *Store) reload() error {
+	b, err := s.load()
+	if err != nil {
+		return err
+	}
+	s.cur.Store(b)
+	log.Printf("loaded certificate %s for %q. expires %s", s.certFile, b.leaf.Subject.CommonName, b.leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// current returns the current bundle, reloading it first if the check
+// interval has passed and any of the files changed
+func (s *Store) current() *bundle {
+	b := s.cur.Load().(*bundle)
+	if time.Since(time.Unix(0, atomic.LoadInt64(&s.checked))) < s.interval {
+		return b
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// another handshake may have checked while we waited
+	if time.Since(time.Unix(0, atomic.LoadInt64(&s.checked))) < s.interval {
+		return s.cur.Load().(*bundle)
+	}
+	atomic.StoreInt64(&s.checked, time.Now().UnixNano())
+
+	modTimes, err := s.modTimes()
+	if err != nil {
+		log.Printf("could not check certificate files. keeping current certificate. error: %v", err)
+		return b
+	}
+	if modTimes == b.modTimes {
+		return b
+	}
+	// a cert and key written one after the other may not match yet.
+	// the old bundle is kept and the files are tried again next check.
+	if err := s.reload(); err != nil {
+		log.Printf("could not reload certificate. keeping current certificate. error: %v", err)
+	}
+	return s.cur.Load().(*bundle)
+}
+
+// Certificate returns the current certificate
+func (s *Store) Certificate() *tls.Certificate {
+	return s.current().cert
+}
+
+// Leaf returns the parsed current certificate
+func (s *Store) Leaf() *x509.Certificate {
+	return s.current().leaf
+}
+
+// CA returns the first certificate in the current CA file
+func (s *Store) CA() *x509.Certificate {
+	return s.current().ca
+}
+
+// Pool returns the current CA pool
+func (s *Store) Pool() *x509.CertPool {
+	return s.current().pool
+}
+
+// GetCertificate is a tls.Config.GetCertificate returning the current certificate
+func (s *Store) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.Certificate(), nil
+}
+
+// GetClientCertificate is a tls.Config.GetClientCertificate returning
+// the current certificate
+func (s *Store) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return s.Certificate(), nil
+}
+
+// VerifyServer is a tls.Config.VerifyConnection checking the server's
+// chain and host name against the current CA pool. the config must set
+// InsecureSkipVerify so the static RootCAs aren't used instead.
+func (s *Store) VerifyServer(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         s.Pool(),
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+func (s *Store) load() (*bundle, error) {
+	// stat first so a file changed while loading is loaded again
+	modTimes, err := s.modTimes()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf = leaf
+
+	caCert, err := ioutil.ReadFile(s.caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if ok := pool.AppendCertsFromPEM(caCert); !ok {
+		return nil, fmt.Errorf("failed to append certs from pem in %s", s.caFile)
+	}
+	// AppendCertsFromPEM found a certificate so the loop ends on one
+	block, rest := pem.Decode(caCert)
+	for block.Type != "CERTIFICATE" {
+		block, rest = pem.Decode(rest)
+	}
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bundle{cert: &cert, leaf: leaf, pool: pool, ca: ca, modTimes: modTimes}, nil
+}
+
+func (s *Store) modTimes() ([3]time.Time, error) {
+	var modTimes [3]time.Time
+	for i, file := range []string{s.certFile, s.keyFile, s.caFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+)
+
+// ListArtifacts requests the artifacts kept from job matching id and
+// outputs their paths and sizes
+func (cl *Client) ListArtifacts(id string) error {
+	var resp api.ArtifactList
+	if err := cl.call(context.Background(), http.MethodGet, "/jobs/"+id+"/artifacts", nil, http.StatusOK, &resp); err != nil {
+		return err
+	}
+
+	fmt.Printf("[ARTIFACTS] => %s\n", id)
+	for _, a := range resp.Artifacts {
+		fmt.Printf(" -%s (%d bytes)\n", a.Path, a.Size)
+	}
+	return nil
+}
+
+// GetArtifact downloads artifact p of job matching id to dest. with
+// dest "" it is saved in the current directory under its base name.
+func (cl *Client) GetArtifact(id, p, dest string) error {
+	if dest == "" {
+		dest = filepath.Base(filepath.FromSlash(p))
+	}
+
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	r, err := cl.Get(cl.v1URL("/jobs/" + id + "/artifacts/" + strings.Join(parts, "/")))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		return decodeError(r, body)
+	}
+
+	// write next to dest and rename so a failed download leaves no
+	// partial file behind
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), ".artifact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, r.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return err
+	}
+
+	fmt.Printf("[ARTIFACT SAVED] => %s (%d bytes)\n", dest, n)
+	return nil
+}
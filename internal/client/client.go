@@ -1,21 +1,30 @@
 package client
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+	"github.com/bradyfontenot/ljw/internal/certs"
 )
 
+// DefaultServer is the server New connects to
+const DefaultServer = "https://localhost:8080"
+
 const (
-	baseURI  = "https://localhost:8080"
 	certFile = "ssl/client.crt"
 	keyFile  = "ssl/client.key"
 	caFile   = "ssl/ca.crt"
@@ -24,64 +33,145 @@ const (
 // Client implements an http client
 type Client struct {
 	*http.Client
+	// base is the scheme and host requests are sent to
+	base string
+	// certs is nil for unix socket servers
+	certs *certs.Store
+	// namespace the job requests act in. "" is the default namespace
+	namespace string
 }
 
-// New creates and returns a new Client
+// New creates and returns a new Client for DefaultServer
 func New() (*Client, error) {
+	return NewWithURL(DefaultServer)
+}
+
+// NewWithURL creates and returns a new Client for the server at rawURL.
+// https:// servers are reached over mTLS with the certificates in ssl.
+// unix:///path/to/socket servers are reached over a local unix socket,
+// where the server identifies the client by its uid instead.
+func NewWithURL(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "https":
+		return newTLS(u.Scheme + "://" + u.Host)
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("%s has no socket path", rawURL)
+		}
+		return newUnix(u.Path), nil
+	}
+	return nil, fmt.Errorf("unsupported server url %s. must be https:// or unix://", rawURL)
+}
+
+func newTLS(base string) (*Client, error) {
 
 	// load certs and config TLS for client
-	tlsConfig, err := setupTLS()
+	store, err := certs.Load(certFile, keyFile, caFile, certs.DefaultCheckInterval)
 	if err != nil {
 		return nil, err
 	}
 
 	tr := &http.Transport{
-		TLSClientConfig:     tlsConfig,
+		TLSClientConfig:     setupTLS(store),
 		TLSHandshakeTimeout: time.Duration(15 * time.Second),
 	}
 
 	return &Client{
-		&http.Client{
+		Client: &http.Client{
 			Timeout:   time.Duration(30 * time.Second),
 			Transport: tr,
 		},
+		base:  base,
+		certs: store,
 	}, nil
 }
 
-// setupTLS sets up Authentication and builds tlsConfig for the client
-func setupTLS() (*tls.Config, error) {
+func newUnix(socket string) *Client {
+	var d net.Dialer
+	tr := &http.Transport{
+		// every request goes to the socket whatever its host
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
 
-	// load certificate authority file
-	caCert, err := ioutil.ReadFile(caFile)
-	if err != nil {
-		return nil, err
+	return &Client{
+		Client: &http.Client{
+			Timeout:   time.Duration(30 * time.Second),
+			Transport: tr,
+		},
+		base: "http://unix",
 	}
+}
 
-	// create pool for accepted certificate authorities and add ca.
-	caCertPool := x509.NewCertPool()
-	if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
-		return nil, errors.New("failed to append certs from pem")
+// SetNamespace makes the client act on the jobs of namespace ns.
+// listing jobs without a namespace shows every namespace the client
+// can read.
+func (cl *Client) SetNamespace(ns string) {
+	cl.namespace = ns
+}
+
+// v1URL returns the url of path under /api/v1, moved under the
+// client's namespace if it has one
+func (cl *Client) v1URL(path string) string {
+	if cl.namespace != "" {
+		path = "/namespaces/" + url.PathEscape(cl.namespace) + path
 	}
+	return cl.base + api.V1 + path
+}
 
-	// load certificate and private key files
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-	if err != nil {
-		return nil, err
+// CertExpiry returns when the client's certificate expires. it is zero
+// for unix socket servers, which don't use one.
+func (cl *Client) CertExpiry() time.Time {
+	if cl.certs == nil {
+		return time.Time{}
 	}
+	return cl.certs.Leaf().NotAfter
+}
 
+// setupTLS sets up Authentication and builds tlsConfig for the client.
+// the certificate and CA are reloaded when their files change, so long
+// running clients keep working across renewals.
+func setupTLS(store *certs.Store) *tls.Config {
 	return &tls.Config{
-		RootCAs:      caCertPool,
-		Certificates: []tls.Certificate{cert},
-	}, nil
+		// the server sets the floor. TLS 1.3 unless it was lowered
+		MinVersion:           tls.VersionTLS12,
+		CurvePreferences:     []tls.CurveID{tls.X25519, tls.CurveP256},
+		GetClientCertificate: store.GetClientCertificate,
+		// the server is verified by VerifyServer against the current CA
+		// pool instead of a fixed RootCAs
+		InsecureSkipVerify: true,
+		VerifyConnection:   store.VerifyServer,
+	}
 }
 
-// ListJobs requests a list of all jobs and outputs id and status
-func (cl *Client) ListJobs() error {
-	type response struct {
-		IDList []string `json:"idList"`
+// decodeError turns the body of a failed request into an *api.Error.
+// bodies that aren't an error envelope become the error message.
+func decodeError(r *http.Response, body []byte) error {
+	var resp api.ErrorResponse
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Error == nil {
+		return &api.Error{
+			Status:    r.StatusCode,
+			Message:   strings.TrimSpace(string(body)),
+			RequestID: r.Header.Get(api.RequestIDHeader),
+		}
 	}
 
-	r, err := cl.Get(baseURI + "/api/jobs")
+	resp.Error.Status = r.StatusCode
+	if secs, err := strconv.Atoi(r.Header.Get("Retry-After")); err == nil {
+		resp.Error.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return resp.Error
+}
+
+// ListJobs requests a list of all jobs and outputs id and status
+func (cl *Client) ListJobs() error {
+	r, err := cl.Get(cl.v1URL("/jobs"))
 	if err != nil {
 		return err
 	}
@@ -93,76 +183,372 @@ func (cl *Client) ListJobs() error {
 	}
 
 	if r.StatusCode != http.StatusOK {
-		return errors.New(string(body))
+		return decodeError(r, body)
 	}
 
-	var resp response
+	var resp api.JobList
 	err = json.Unmarshal([]byte(body), &resp)
 	if err != nil {
 		return err
 	}
 
 	fmt.Println("[ALL JOBS]")
-	for _, v := range resp.IDList {
-		fmt.Println(" -ID:", v)
+	for _, v := range resp.Jobs {
+		id := v.ID
+		if v.Namespace != "" && v.Namespace != api.DefaultNamespace && cl.namespace == "" {
+			id = v.Namespace + "/" + v.ID
+		}
+		fmt.Println(" -ID:", id, "=>", v.Status)
 	}
 
 	return nil
 }
 
+// job status values that mean a job is done
+const (
+	finished = "FINISHED"
+	canceled = "CANCELED"
+	failed   = "FAILED"
+)
+
+// StartOptions are optional settings for a new job
+type StartOptions struct {
+	Labels map[string]string
+	// Webhooks are urls the server POSTs to when the job ends
+	Webhooks []string
+	// Secrets are given to the job in its environment
+	Secrets []api.SecretRef
+	// Dir is the working directory of the job on the server
+	Dir string
+	// Artifacts are globs of files in Dir the server keeps once the job ends
+	Artifacts []string
+}
+
 // StartJob posts a request to start a new job
-func (cl *Client) StartJob(cmd []string) error {
-	type request struct {
-		Cmd []string `json:"cmd"`
+func (cl *Client) StartJob(cmd []string, opts StartOptions) error {
+	resp, err := cl.startJob(cmd, opts)
+	if err != nil {
+		return err
 	}
 
-	type response struct {
-		ID     string `json:"id"`
-		Cmd    string `json:"cmd"`
-		Status string `json:"status"`
-		Output string `json:"output"`
+	fmt.Printf("[JOB ADDED]\n[ID]: \t\t%s\n[COMMAND]: \t%s\n[STATUS]: \t%s\n", resp.ID, strings.Join(resp.Cmd, " "), resp.Status)
+	if resp.ExitCode != nil {
+		fmt.Printf("[EXIT CODE]: \t%d\n", *resp.ExitCode)
 	}
+	fmt.Println()
+
+	return nil
+}
 
-	msg := request{cmd}
+// startJob posts cmd to the server and returns the new job
+func (cl *Client) startJob(cmd []string, opts StartOptions) (api.Job, error) {
+	var resp api.Job
+
+	msg := api.StartJobRequest{
+		Cmd:       cmd,
+		Labels:    opts.Labels,
+		Webhooks:  opts.Webhooks,
+		Secrets:   opts.Secrets,
+		Dir:       opts.Dir,
+		Artifacts: opts.Artifacts,
+	}
 	reqBody, err := json.Marshal(msg)
 	if err != nil {
-		return err
+		return resp, err
 	}
 
-	r, err := cl.Post(baseURI+"/api/jobs", "application/json", bytes.NewBuffer(reqBody))
+	r, err := cl.Post(cl.v1URL("/jobs"), "application/json", bytes.NewBuffer(reqBody))
 	if err != nil {
-		return err
+		return resp, err
 	}
 
 	defer r.Body.Close()
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return err
+		return resp, err
 	}
 
 	if r.StatusCode != http.StatusCreated {
-		return errors.New(string(body))
+		return resp, decodeError(r, body)
 	}
 
-	var resp response
 	err = json.Unmarshal([]byte(body), &resp)
+	return resp, err
+}
+
+// RunJob starts a job, copies its output to stdout as it is written
+// and returns the job's exit code once it ends. if ctx is canceled
+// while the job runs, a stop request is sent and RunJob keeps
+// following the job until the server reports it has ended.
+func (cl *Client) RunJob(ctx context.Context, cmd []string, opts StartOptions) (int, error) {
+	job, err := cl.startJob(cmd, opts)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	return cl.runJob(ctx, job)
+}
 
-	fmt.Printf("[JOB ADDED]\n[ID]: \t\t%s\n[COMMAND]: \t%s\n[STATUS]: \t%s\n[OUTPUT]:\n%s\n", resp.ID, resp.Cmd, resp.Status, resp.Output)
-	fmt.Print("[OUTPUT END]\n\n")
+// runJob follows a job that was just started. see RunJob
+func (cl *Client) runJob(ctx context.Context, job api.Job) (int, error) {
+	fmt.Fprintf(os.Stderr, "[JOB ADDED] => %s\n", job.ID)
+
+	pollCtx := ctx
+	offset := 0
+	for {
+		resp, err := cl.followJob(pollCtx, job.ID, offset)
+		if err != nil {
+			if pollCtx.Err() == nil {
+				return 0, err
+			}
+			// interrupted. ask the server to stop the job and keep
+			// following it without ctx so we still get the exit code.
+			// give up on the job if it can't be stopped.
+			if _, err := cl.stopJob(job.ID); err != nil {
+				return 0, fmt.Errorf("could not stop job %s: %w", job.ID, err)
+			}
+			fmt.Fprintf(os.Stderr, "[JOB STOPPING] => %s\n", job.ID)
+			pollCtx = context.Background()
+			continue
+		}
+
+		fmt.Print(resp.Output)
+		offset = resp.Offset
+
+		if isDone(resp.Status) {
+			if resp.ExitCode == nil {
+				return 0, fmt.Errorf("job %s ended with status %s but no exit code", job.ID, resp.Status)
+			}
+			return *resp.ExitCode, nil
+		}
+	}
+}
+
+// followJob waits for output written by job matching id since offset
+func (cl *Client) followJob(ctx context.Context, id string, offset int) (api.JobOutput, error) {
+	var resp api.JobOutput
+
+	q := url.Values{}
+	q.Set("offset", strconv.Itoa(offset))
+	req, err := http.NewRequestWithContext(ctx, "GET", cl.v1URL("/jobs/"+id+"/output?"+q.Encode()), nil)
+	if err != nil {
+		return resp, err
+	}
+
+	r, err := cl.Do(req)
+	if err != nil {
+		return resp, err
+	}
+
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return resp, err
+	}
+
+	if r.StatusCode != http.StatusOK {
+		return resp, decodeError(r, body)
+	}
+
+	err = json.Unmarshal([]byte(body), &resp)
+	return resp, err
+}
+
+// ErrWaitTimeout is returned by WaitJobs when ctx expires first
+var ErrWaitTimeout = errors.New("timed out waiting for jobs to end")
+
+// WaitJobs blocks until the jobs matching ids have ended and prints the
+// final status and exit code of each. if first is set it returns as
+// soon as any one of them ends. the returned exit code is that of the
+// job that ended when first is set, otherwise the first non-zero exit
+// code in ids order.
+func (cl *Client) WaitJobs(ctx context.Context, ids []string, first bool) (int, error) {
+	type result struct {
+		id       string
+		status   string
+		exitCode int
+		err      error
+	}
+
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan result, len(ids))
+	for _, id := range ids {
+		go func(id string) {
+			status, code, err := cl.waitJob(waitCtx, id)
+			results <- result{id, status, code, err}
+		}(id)
+	}
+
+	codes := make(map[string]int)
+	for range ids {
+		res := <-results
+		if res.err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return 0, ErrWaitTimeout
+			}
+			return 0, fmt.Errorf("job %s: %w", res.id, res.err)
+		}
+
+		fmt.Printf("[JOB %s] => %s (EXIT CODE %d)\n", res.id, res.status, res.exitCode)
+		if first {
+			return res.exitCode, nil
+		}
+		codes[res.id] = res.exitCode
+	}
+
+	for _, id := range ids {
+		if codes[id] != 0 {
+			return codes[id], nil
+		}
+	}
+	return 0, nil
+}
+
+// waitJob long-polls the server until job matching id ends or ctx is done
+// and returns the job's final status and exit code.
+func (cl *Client) waitJob(ctx context.Context, id string) (string, int, error) {
+	for {
+		req, err := http.NewRequestWithContext(ctx, "GET", cl.v1URL("/jobs/"+id+"/wait"), nil)
+		if err != nil {
+			return "", 0, err
+		}
+
+		r, err := cl.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", 0, ctx.Err()
+			}
+			return "", 0, err
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return "", 0, err
+		}
+
+		if r.StatusCode != http.StatusOK {
+			return "", 0, decodeError(r, body)
+		}
+
+		var resp api.Job
+		if err := json.Unmarshal([]byte(body), &resp); err != nil {
+			return "", 0, err
+		}
+
+		if isDone(resp.Status) && resp.ExitCode != nil {
+			return resp.Status, *resp.ExitCode, nil
+		}
+	}
+}
+
+// WatchEvents prints the server's job event feed until ctx is done.
+// filter holds the job, owner and label query params for /api/v1/events.
+// if cursor is set the feed resumes after that event id. the server
+// closes the stream periodically; WatchEvents reconnects from the last
+// event it printed.
+func (cl *Client) WatchEvents(ctx context.Context, filter url.Values, cursor string) error {
+	return cl.watchEvents(ctx, filter, cursor, printEvent)
+}
 
+func printEvent(e api.Event) error {
+	if e.Type == "gap" {
+		fmt.Printf("[EVENT GAP] => %s events after %d were dropped before they could be sent\n", strings.Join(e.Dropped, " and "), e.ID)
+		return nil
+	}
+	id := e.JobID
+	if e.Namespace != "" && e.Namespace != api.DefaultNamespace {
+		id = e.Namespace + "/" + e.JobID
+	}
+	fmt.Printf("[EVENT %d] %s %-8s job=%s status=%s", e.ID, e.Time.Local().Format("15:04:05"), strings.ToUpper(e.Type), id, e.Status)
+	if e.ExitCode != nil {
+		fmt.Printf(" exit=%d", *e.ExitCode)
+	}
+	if e.Output != "" {
+		fmt.Printf(" | %s", strings.TrimRight(e.Output, "\n"))
+	}
+	fmt.Println()
 	return nil
 }
 
-// JobStatus requests the status of job matching id
-func (cl *Client) JobStatus(id string) error {
-	type response struct {
-		Status string `json:"status"`
+// watchEvents calls fn with every event in the feed until ctx is done
+// or fn returns an error, reconnecting when the server closes the stream
+func (cl *Client) watchEvents(ctx context.Context, filter url.Values, cursor string, fn func(api.Event) error) error {
+	for ctx.Err() == nil {
+		last, err := cl.streamEvents(ctx, filter, cursor, fn)
+		if last != "" {
+			cursor = last
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
 	}
+	return nil
+}
 
-	r, err := cl.Get(baseURI + "/api/jobs/" + id)
+// streamEvents reads one server-sent event stream and calls fn with
+// each event. returns the id of the last event handled.
+func (cl *Client) streamEvents(ctx context.Context, filter url.Values, cursor string, fn func(api.Event) error) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", cl.v1URL("/events?"+filter.Encode()), nil)
+	if err != nil {
+		return "", err
+	}
+	if cursor != "" {
+		req.Header.Set("Last-Event-ID", cursor)
+	}
+
+	r, err := cl.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		return "", decodeError(r, body)
+	}
+
+	var last, id, data string
+	scanner := bufio.NewScanner(r.Body)
+	// output events can carry long lines
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && data != "":
+			var e api.Event
+			if err := json.Unmarshal([]byte(data), &e); err != nil {
+				return last, err
+			}
+			if err := fn(e); err != nil {
+				return last, err
+			}
+
+			last, data = id, ""
+		}
+	}
+	return last, scanner.Err()
+}
+
+// isDone reports whether status is a terminal job status
+func isDone(status string) bool {
+	return status == finished || status == canceled || status == failed
+}
+
+// JobStatus requests the status of job matching id
+func (cl *Client) JobStatus(id string) error {
+	r, err := cl.Get(cl.v1URL("/jobs/" + id))
 	if err != nil {
 		return err
 	}
@@ -174,32 +560,65 @@ func (cl *Client) JobStatus(id string) error {
 	}
 
 	if r.StatusCode != http.StatusOK {
-		return errors.New(string(body))
+		return decodeError(r, body)
 	}
 
-	var resp response
+	var resp api.Job
 	err = json.Unmarshal([]byte(body), &resp)
 	if err != nil {
 		return err
 	}
 
 	fmt.Printf("[JOB STATUS] => %s \n", resp.Status)
+	if resp.Redactions > 0 {
+		fmt.Printf("[REDACTIONS] => %d\n", resp.Redactions)
+	}
 
 	return nil
 }
 
 // StopJob requests to delete job matching id
 func (cl *Client) StopJob(id string) error {
-	type response struct {
-		Success bool `json:"success"`
+	success, err := cl.stopJob(id)
+	if err != nil {
+		return err
 	}
 
-	req, err := http.NewRequest("DELETE", baseURI+"/api/jobs/"+id, nil)
+	fmt.Printf("[JOB STOPPED] => %s \n", strings.ToUpper(strconv.FormatBool(success)))
+
+	return nil
+}
+
+// stopJob sends the stop request and reports whether the job was stopped
+func (cl *Client) stopJob(id string) (bool, error) {
+	req, err := http.NewRequest("DELETE", cl.v1URL("/jobs/"+id), nil)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	r, err := cl.Do(req)
+	if err != nil {
+		return false, err
+	}
+
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return false, err
+	}
+
+	if r.StatusCode != http.StatusOK {
+		return false, decodeError(r, body)
+	}
+
+	var resp api.StopJobResponse
+	err = json.Unmarshal([]byte(body), &resp)
+	return resp.Stopped, err
+}
+
+// RemoveJob requests to purge the record of a job that has ended
+func (cl *Client) RemoveJob(id string) error {
+	r, err := cl.Post(cl.v1URL("/jobs/"+id+"/purge"), "application/json", nil)
 	if err != nil {
 		return err
 	}
@@ -211,29 +630,88 @@ func (cl *Client) StopJob(id string) error {
 	}
 
 	if r.StatusCode != http.StatusOK {
-		return errors.New(string(body))
+		return decodeError(r, body)
 	}
 
-	var resp response
+	fmt.Printf("[JOB REMOVED] => %s \n", id)
+
+	return nil
+}
+
+// Quota requests the client's limits and usage and outputs them.
+// unlimited quotas are shown as "-".
+func (cl *Client) Quota() error {
+	var q api.Quota
+	if err := cl.call(context.Background(), http.MethodGet, "/quota", nil, http.StatusOK, &q); err != nil {
+		return err
+	}
+
+	limit := func(n float64) string {
+		if n == 0 {
+			return "-"
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	rate := "-"
+	if q.Limits.Rate > 0 {
+		rate = fmt.Sprintf("%s/s, burst %d, %d left", limit(q.Limits.Rate), q.Limits.Burst, q.Usage.RateRemaining)
+	}
+
+	fmt.Printf("[QUOTA] => %s in %s\n", q.Identity, q.Namespace)
+	fmt.Printf(" -Rate: %s\n", rate)
+	fmt.Printf(" -Running: %d of %s\n", q.Usage.Running, limit(float64(q.Limits.MaxRunning)))
+	fmt.Printf(" -Queued: %d of %d\n", q.Usage.Queued, q.Limits.MaxQueued)
+	fmt.Printf(" -CPU Seconds Today: %.1f of %s. resets %s\n", q.Usage.CPUSeconds, limit(q.Limits.CPUSecondsPerDay), q.Usage.CPUReset.Local().Format(time.RFC3339))
+	return nil
+}
+
+// PruneOptions is a retention policy to prune with. leave all
+// fields empty to use the server's own policy.
+type PruneOptions = api.PruneRequest
+
+// PruneJobs requests removal of ended jobs outside the retention policy
+func (cl *Client) PruneJobs(opts PruneOptions) error {
+	var reqBody []byte
+	if opts != (PruneOptions{}) {
+		var err error
+		reqBody, err = json.Marshal(opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	r, err := cl.Post(cl.base+api.V1+"/prune", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err
+	}
+
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if r.StatusCode != http.StatusOK {
+		return decodeError(r, body)
+	}
+
+	var resp api.PruneResponse
 	err = json.Unmarshal([]byte(body), &resp)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("[JOB STOPPED] => %s \n", strings.ToUpper(strconv.FormatBool(resp.Success)))
+	fmt.Printf("[JOBS REMOVED] => %d\n", len(resp.Removed))
+	for _, v := range resp.Removed {
+		fmt.Println(" -ID:", v)
+	}
 
 	return nil
 }
 
 // GetJobLog ....
 func (cl *Client) GetJobLog(id string) error {
-	type response struct {
-		Cmd    string `json:"cmd"`
-		Status string `json:"status"`
-		Output string `json:"output"`
-	}
-
-	r, err := cl.Get(baseURI + "/api/jobs/" + id + "/log")
+	r, err := cl.Get(cl.v1URL("/jobs/" + id + "/log"))
 	if err != nil {
 		return err
 	}
@@ -245,16 +723,16 @@ func (cl *Client) GetJobLog(id string) error {
 	}
 
 	if r.StatusCode != http.StatusOK {
-		return errors.New(string(body))
+		return decodeError(r, body)
 	}
 
-	var resp response
+	var resp api.JobLog
 	err = json.Unmarshal([]byte(body), &resp)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("[JOB LOG]\n[ID]: \t\t%s\n[COMMAND]: \t%s\n[STATUS]: \t%s\n[OUTPUT]:\n%s\n", id, resp.Cmd, resp.Status, resp.Output)
+	fmt.Printf("[JOB LOG]\n[ID]: \t\t%s\n[COMMAND]: \t%s\n[STATUS]: \t%s\n[OUTPUT]:\n%s\n", id, strings.Join(resp.Cmd, " "), resp.Status, resp.Output)
 	fmt.Print("[OUTPUT END]\n\n")
 
 	return nil
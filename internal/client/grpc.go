@@ -0,0 +1,238 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+	"github.com/bradyfontenot/ljw/internal/api/ljwpb"
+	"github.com/bradyfontenot/ljw/internal/certs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// trailers the server puts the api error code and the seconds to wait
+// before retrying a rate limited call in
+const (
+	errorCodeKey  = "ljw-error-code"
+	retryAfterKey = "retry-after"
+)
+
+// grpcStatuses maps gRPC codes back to the http status of api errors
+var grpcStatuses = map[codes.Code]int{
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.NotFound:           http.StatusNotFound,
+	codes.FailedPrecondition: http.StatusConflict,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.Internal:           http.StatusInternalServerError,
+}
+
+// GRPCClient talks to the server's gRPC API with the certificates in ssl
+type GRPCClient struct {
+	conn  *grpc.ClientConn
+	jobs  ljwpb.JobServiceClient
+	certs *certs.Store
+}
+
+// NewGRPC connects to the gRPC API at addr. the connection is made
+// lazily, so a server that is down shows up as an error on first use.
+func NewGRPC(addr string) (*GRPCClient, error) {
+	store, err := certs.Load(certFile, keyFile, caFile, certs.DefaultCheckInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(setupTLS(store))))
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCClient{conn: conn, jobs: ljwpb.NewJobServiceClient(conn), certs: store}, nil
+}
+
+// CertExpiry returns when the client's certificate expires
+func (gc *GRPCClient) CertExpiry() time.Time {
+	return gc.certs.Leaf().NotAfter
+}
+
+// Close implements Jobs
+func (gc *GRPCClient) Close() error {
+	return gc.conn.Close()
+}
+
+// Start implements Jobs
+func (gc *GRPCClient) Start(ctx context.Context, cmd []string, opts StartOptions) (api.Job, error) {
+	var trailer metadata.MD
+	job, err := gc.jobs.Start(ctx, &ljwpb.StartRequest{Cmd: cmd, Labels: opts.Labels, Webhooks: opts.Webhooks}, grpc.Trailer(&trailer))
+	if err != nil {
+		return api.Job{}, grpcError(err, trailer)
+	}
+	return apiJob(job), nil
+}
+
+// Stop implements Jobs
+func (gc *GRPCClient) Stop(ctx context.Context, id string) (bool, error) {
+	var trailer metadata.MD
+	resp, err := gc.jobs.Stop(ctx, &ljwpb.StopRequest{Id: id}, grpc.Trailer(&trailer))
+	if err != nil {
+		return false, grpcError(err, trailer)
+	}
+	return resp.Stopped, nil
+}
+
+// Job implements Jobs
+func (gc *GRPCClient) Job(ctx context.Context, id string) (api.Job, error) {
+	var trailer metadata.MD
+	job, err := gc.jobs.Get(ctx, &ljwpb.GetRequest{Id: id}, grpc.Trailer(&trailer))
+	if err != nil {
+		return api.Job{}, grpcError(err, trailer)
+	}
+	return apiJob(job), nil
+}
+
+// List implements Jobs
+func (gc *GRPCClient) List(ctx context.Context) ([]api.Job, error) {
+	var trailer metadata.MD
+	resp, err := gc.jobs.List(ctx, &ljwpb.ListRequest{}, grpc.Trailer(&trailer))
+	if err != nil {
+		return nil, grpcError(err, trailer)
+	}
+
+	jobs := []api.Job{}
+	for _, job := range resp.Jobs {
+		jobs = append(jobs, apiJob(job))
+	}
+	return jobs, nil
+}
+
+// StreamLogs implements Jobs with a single server stream
+func (gc *GRPCClient) StreamLogs(ctx context.Context, id string, offset int, fn func(string) error) (api.Job, error) {
+	stream, err := gc.jobs.StreamLogs(ctx, &ljwpb.StreamLogsRequest{Id: id, Offset: int64(offset), Follow: true})
+	if err != nil {
+		return api.Job{}, grpcError(err, nil)
+	}
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return api.Job{}, grpcError(err, stream.Trailer())
+		}
+		if len(chunk.Lines) > 0 {
+			if err := fn(strings.Join(chunk.Lines, "")); err != nil {
+				return api.Job{}, err
+			}
+		}
+	}
+	return gc.Job(ctx, id)
+}
+
+// WatchStatus implements Jobs with a single server stream
+func (gc *GRPCClient) WatchStatus(ctx context.Context, filter StatusFilter, fn func(api.Event) error) error {
+	stream, err := gc.jobs.WatchStatus(ctx, &ljwpb.WatchStatusRequest{
+		JobId:  filter.JobID,
+		Owner:  filter.Owner,
+		Labels: filter.Labels,
+	})
+	if err != nil {
+		return grpcError(err, nil)
+	}
+
+	for {
+		e, err := stream.Recv()
+		if err == io.EOF || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return grpcError(err, stream.Trailer())
+		}
+
+		if err := fn(api.Event{
+			ID:       e.Id,
+			Type:     e.Type,
+			Time:     e.Time.AsTime(),
+			JobID:    e.JobId,
+			Owner:    e.Owner,
+			Labels:   labels(e.Labels),
+			Status:   e.Status,
+			ExitCode: exitCode(e.ExitCode),
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// grpcError turns a gRPC status into an *api.Error like the REST API
+// returns, using the error code the server sent in the trailer
+func grpcError(err error, trailer metadata.MD) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	code := ""
+	if v := trailer.Get(errorCodeKey); len(v) > 0 {
+		code = v[0]
+	}
+	httpStatus, ok := grpcStatuses[st.Code()]
+	if !ok || code == "" {
+		// not an api error, such as a failed connection
+		return errors.New(st.Message())
+	}
+	e := &api.Error{Status: httpStatus, Code: code, Message: st.Message()}
+	if v := trailer.Get(retryAfterKey); len(v) > 0 {
+		if secs, err := strconv.Atoi(v[0]); err == nil {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		}
+	}
+	return e
+}
+
+// apiJob converts a job from the gRPC API to its REST form
+func apiJob(job *ljwpb.Job) api.Job {
+	return api.Job{
+		ID:       job.Id,
+		Cmd:      job.Cmd,
+		Owner:    job.Owner,
+		Labels:   labels(job.Labels),
+		Status:   job.Status,
+		ExitCode: exitCode(job.ExitCode),
+		Created:  job.Created.AsTime(),
+		Started:  optionalTime(job.Started),
+		Ended:    optionalTime(job.Ended),
+	}
+}
+
+func labels(l map[string]string) map[string]string {
+	if l == nil {
+		return map[string]string{}
+	}
+	return l
+}
+
+func exitCode(code *int32) *int {
+	if code == nil {
+		return nil
+	}
+	c := int(*code)
+	return &c
+}
+
+func optionalTime(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
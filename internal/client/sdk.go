@@ -0,0 +1,186 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+)
+
+// Jobs is the job API for Go programs. Client implements it over REST
+// and GRPCClient over gRPC, so callers can pick either transport.
+type Jobs interface {
+	// Start starts cmd as a job owned by the caller
+	Start(ctx context.Context, cmd []string, opts StartOptions) (api.Job, error)
+	// Stop stops a job and reports whether it was still running
+	Stop(ctx context.Context, id string) (bool, error)
+	// Job returns a job
+	Job(ctx context.Context, id string) (api.Job, error)
+	// List returns all jobs in id order
+	List(ctx context.Context) ([]api.Job, error)
+	// StreamLogs calls fn with the output of a job from offset lines in
+	// as it is written, until the job ends. it returns the ended job.
+	StreamLogs(ctx context.Context, id string, offset int, fn func(output string) error) (api.Job, error)
+	// WatchStatus calls fn with every status change of jobs matching
+	// filter until ctx is done or fn returns an error
+	WatchStatus(ctx context.Context, filter StatusFilter, fn func(api.Event) error) error
+	Close() error
+}
+
+var (
+	_ Jobs = (*Client)(nil)
+	_ Jobs = (*GRPCClient)(nil)
+)
+
+// StatusFilter selects the jobs WatchStatus reports on. empty fields
+// match every job and every label given must be on the job.
+type StatusFilter struct {
+	JobID  string
+	Owner  string
+	Labels map[string]string
+}
+
+// Dial returns a Jobs for the server at rawURL. https:// and unix://
+// servers are reached over REST, see NewWithURL. grpc://host:port
+// servers are reached over gRPC with mTLS, see NewGRPC.
+func Dial(rawURL string) (Jobs, error) {
+	if strings.HasPrefix(rawURL, "grpc://") {
+		return NewGRPC(strings.TrimPrefix(rawURL, "grpc://"))
+	}
+	return NewWithURL(rawURL)
+}
+
+// Start implements Jobs
+func (cl *Client) Start(ctx context.Context, cmd []string, opts StartOptions) (api.Job, error) {
+	var job api.Job
+	req := api.StartJobRequest{Cmd: cmd, Labels: opts.Labels, Webhooks: opts.Webhooks}
+	err := cl.call(ctx, http.MethodPost, "/jobs", req, http.StatusCreated, &job)
+	return job, err
+}
+
+// Stop implements Jobs
+func (cl *Client) Stop(ctx context.Context, id string) (bool, error) {
+	var resp api.StopJobResponse
+	err := cl.call(ctx, http.MethodDelete, "/jobs/"+url.PathEscape(id), nil, http.StatusOK, &resp)
+	return resp.Stopped, err
+}
+
+// Job implements Jobs
+func (cl *Client) Job(ctx context.Context, id string) (api.Job, error) {
+	var job api.Job
+	err := cl.call(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, http.StatusOK, &job)
+	return job, err
+}
+
+// List implements Jobs
+func (cl *Client) List(ctx context.Context) ([]api.Job, error) {
+	var resp api.JobList
+	err := cl.call(ctx, http.MethodGet, "/jobs", nil, http.StatusOK, &resp)
+	return resp.Jobs, err
+}
+
+// StreamLogs implements Jobs by long-polling the job's output
+func (cl *Client) StreamLogs(ctx context.Context, id string, offset int, fn func(string) error) (api.Job, error) {
+	for {
+		var out api.JobOutput
+		path := "/jobs/" + url.PathEscape(id) + "/output?offset=" + strconv.Itoa(offset)
+		if err := cl.call(ctx, http.MethodGet, path, nil, http.StatusOK, &out); err != nil {
+			return api.Job{}, err
+		}
+		if out.Output != "" {
+			if err := fn(out.Output); err != nil {
+				return api.Job{}, err
+			}
+		}
+		offset = out.Offset
+
+		if isDone(out.Status) && out.ExitCode != nil {
+			return cl.Job(ctx, id)
+		}
+	}
+}
+
+// WatchStatus implements Jobs with the event feed, leaving out output
+func (cl *Client) WatchStatus(ctx context.Context, filter StatusFilter, fn func(api.Event) error) error {
+	q := url.Values{}
+	if filter.JobID != "" {
+		q.Set("job", filter.JobID)
+	}
+	if filter.Owner != "" {
+		q.Set("owner", filter.Owner)
+	}
+	for k, v := range filter.Labels {
+		q.Add("label", k+"="+v)
+	}
+
+	return cl.watchEvents(ctx, q, "", func(e api.Event) error {
+		if e.Type == "output" || e.Type == "gap" && !lostLifecycle(e.Dropped) {
+			return nil
+		}
+		return fn(e)
+	})
+}
+
+// lostLifecycle reports whether the kinds dropped by a gap include
+// lifecycle events
+func lostLifecycle(dropped []string) bool {
+	for _, kind := range dropped {
+		if kind == "lifecycle" {
+			return true
+		}
+	}
+	return false
+}
+
+// Close implements Jobs by closing idle connections
+func (cl *Client) Close() error {
+	cl.CloseIdleConnections()
+	return nil
+}
+
+// call sends a request with body encoded as JSON to path under /api/v1,
+// in the client's namespace if it has one, and decodes the response into out. any status but want is an error.
+func (cl *Client) call(ctx context.Context, method, path string, body interface{}, want int, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, cl.v1URL(path), reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	r, err := cl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	respBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if r.StatusCode != want {
+		return decodeError(r, respBody)
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("could not decode response to %s %s. error: %w", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+)
+
+// SetSecret creates or replaces secret name. the value is never shown
+// again by the server.
+func (cl *Client) SetSecret(name, value string) error {
+	var s api.Secret
+	req := api.SetSecretRequest{Value: value}
+	if err := cl.call(context.Background(), http.MethodPut, "/secrets/"+url.PathEscape(name), req, http.StatusOK, &s); err != nil {
+		return err
+	}
+
+	fmt.Printf("[SECRET SET] => %s\n", s.Name)
+	return nil
+}
+
+// ListSecrets requests the names of the secrets and outputs them with
+// who last set them and when
+func (cl *Client) ListSecrets() error {
+	var resp api.SecretList
+	if err := cl.call(context.Background(), http.MethodGet, "/secrets", nil, http.StatusOK, &resp); err != nil {
+		return err
+	}
+
+	fmt.Println("[ALL SECRETS]")
+	for _, s := range resp.Secrets {
+		fmt.Printf(" -%s => set by %s at %s\n", s.Name, s.Owner, s.Updated.Local().Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
+
+// DeleteSecret removes secret name
+func (cl *Client) DeleteSecret(name string) error {
+	var resp api.DeleteSecretResponse
+	if err := cl.call(context.Background(), http.MethodDelete, "/secrets/"+url.PathEscape(name), nil, http.StatusOK, &resp); err != nil {
+		return err
+	}
+
+	fmt.Printf("[SECRET REMOVED] => %s\n", resp.Name)
+	return nil
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+)
+
+// CreateTemplate adds a job template and outputs it
+func (cl *Client) CreateTemplate(req api.CreateTemplateRequest) error {
+	var t api.Template
+	if err := cl.call(context.Background(), http.MethodPost, "/templates", req, http.StatusCreated, &t); err != nil {
+		return err
+	}
+
+	fmt.Printf("[TEMPLATE ADDED]\n[NAME]: \t%s\n[COMMAND]: \t%s\n", t.Name, strings.Join(t.Cmd, " "))
+	if len(t.Params) > 0 {
+		fmt.Printf("[PARAMS]: \t%s\n", params(t))
+	}
+	fmt.Println()
+	return nil
+}
+
+// ListTemplates requests the job templates and outputs their commands
+// and parameters
+func (cl *Client) ListTemplates() error {
+	var resp api.TemplateList
+	if err := cl.call(context.Background(), http.MethodGet, "/templates", nil, http.StatusOK, &resp); err != nil {
+		return err
+	}
+
+	fmt.Println("[ALL TEMPLATES]")
+	for _, t := range resp.Templates {
+		fmt.Printf(" -%s => %s\n", t.Name, strings.Join(t.Cmd, " "))
+		if len(t.Params) > 0 {
+			fmt.Printf("    params: %s\n", params(t))
+		}
+	}
+	return nil
+}
+
+// RunTemplate starts a job from template name with params and follows
+// it like RunJob
+func (cl *Client) RunTemplate(ctx context.Context, name string, params map[string]string, opts StartOptions) (int, error) {
+	var job api.Job
+	req := api.StartTemplateRequest{Params: params, Labels: opts.Labels, Webhooks: opts.Webhooks, Secrets: opts.Secrets}
+	if err := cl.call(ctx, http.MethodPost, "/templates/"+url.PathEscape(name)+"/start", req, http.StatusCreated, &job); err != nil {
+		return 0, err
+	}
+	return cl.runJob(ctx, job)
+}
+
+// params describes the parameters of t as name or name=default
+func params(t api.Template) string {
+	var list []string
+	for name, p := range t.Params {
+		if p.Default != nil {
+			name += "=" + *p.Default
+		}
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return strings.Join(list, " ")
+}
@@ -0,0 +1,267 @@
+// Package metrics implements the few Prometheus metric types the
+// server needs and writes them in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are histogram buckets suited to request latencies in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// escaper escapes label values as the text format requires
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Collector writes one or more metric families
+type Collector interface {
+	collect(w *bufio.Writer)
+}
+
+// Registry holds collectors and serves them over http
+type Registry struct {
+	collectors []Collector
+	sync.Mutex
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds collectors to the registry
+func (r *Registry) Register(cs ...Collector) {
+	r.Lock()
+	defer r.Unlock()
+	r.collectors = append(r.collectors, cs...)
+}
+
+// ServeHTTP writes every registered metric in the text format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	r.Lock()
+	cs := append([]Collector(nil), r.collectors...)
+	r.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	for _, c := range cs {
+		c.collect(bw)
+	}
+	bw.Flush()
+}
+
+// vec holds one value per combination of label values
+type vec struct {
+	name   string
+	help   string
+	labels []string
+	sync.Mutex
+}
+
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs renders {k="v",...} for the label values in key plus extra
+func (v *vec) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(v.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, v.labels[i]+`="`+escaper.Replace(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escaper.Replace(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (v *vec) header(w *bufio.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, typ)
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	vec
+	values map[string]float64
+}
+
+// NewCounterVec creates a counter with the given label names
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{
+		vec:    vec{name: name, help: help, labels: labels},
+		values: make(map[string]float64),
+	}
+}
+
+// Add increases the counter for labelValues by delta
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.Lock()
+	defer c.Unlock()
+	c.values[key] += delta
+}
+
+// Inc increases the counter for labelValues by one
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value returns the counter for labelValues
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+	c.Lock()
+	defer c.Unlock()
+	return c.values[key]
+}
+
+func (c *CounterVec) collect(w *bufio.Writer) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.header(w, "counter")
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key), formatFloat(c.values[key]))
+	}
+}
+
+// histogram is the state of one labelled histogram
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	vec
+	buckets []float64
+	values  map[string]*histogram
+}
+
+// NewHistogramVec creates a histogram with the given upper bounds and label names
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &HistogramVec{
+		vec:     vec{name: name, help: help, labels: labels},
+		buckets: b,
+		values:  make(map[string]*histogram),
+	}
+}
+
+// Observe records v for labelValues
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.Lock()
+	defer h.Unlock()
+
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+func (h *HistogramVec) collect(w *bufio.Writer) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.header(w, "histogram")
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		hist := h.values[key]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", formatFloat(upper)), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(key), hist.count)
+	}
+}
+
+// GaugeFunc is a gauge partitioned by labels whose values are read
+// from fn every time the metric is collected. fn returns values keyed
+// by the label values joined with ",".
+type GaugeFunc struct {
+	vec
+	fn func() map[string]float64
+}
+
+// NewGaugeFunc creates a gauge backed by fn
+func NewGaugeFunc(name, help string, fn func() map[string]float64, labels ...string) *GaugeFunc {
+	return &GaugeFunc{
+		vec: vec{name: name, help: help, labels: labels},
+		fn:  fn,
+	}
+}
+
+func (g *GaugeFunc) collect(w *bufio.Writer) {
+	g.write(w, "gauge")
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer, typ string) {
+	values := g.fn()
+
+	g.header(w, typ)
+	for _, k := range sortedKeys(values) {
+		key := strings.Replace(k, ",", "\xff", -1)
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(key), formatFloat(values[k]))
+	}
+}
+
+// CounterFunc is a counter partitioned by labels whose values are read
+// from fn every time the metric is collected, for counts kept elsewhere
+// such as by the kernel
+type CounterFunc struct {
+	GaugeFunc
+}
+
+// NewCounterFunc creates a counter backed by fn
+func NewCounterFunc(name, help string, fn func() map[string]float64, labels ...string) *CounterFunc {
+	return &CounterFunc{GaugeFunc: *NewGaugeFunc(name, help, fn, labels...)}
+}
+
+func (c *CounterFunc) collect(w *bufio.Writer) {
+	c.write(w, "counter")
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ProcessCollectors returns metrics of the running process under the
+// names Prometheus client libraries use. RSS and open fds are read
+// from /proc and are left out where it doesn't exist.
+func ProcessCollectors() []Collector {
+	return []Collector{
+		NewCounterFunc("process_cpu_seconds_total", "User and system CPU time used by the process.", processCPU),
+		NewGaugeFunc("process_resident_memory_bytes", "Resident memory of the process.", processRSS),
+		NewGaugeFunc("process_open_fds", "Open file descriptors of the process.", processFDs),
+		NewGaugeFunc("go_goroutines", "Goroutines that currently exist.", func() map[string]float64 {
+			return map[string]float64{"": float64(runtime.NumGoroutine())}
+		}),
+	}
+}
+
+func processCPU() map[string]float64 {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return nil
+	}
+	secs := func(tv syscall.Timeval) float64 {
+		return float64(tv.Sec) + float64(tv.Usec)/1e6
+	}
+	return map[string]float64{"": secs(usage.Utime) + secs(usage.Stime)}
+}
+
+// processRSS reads the resident set size from /proc/self/statm, which
+// counts it in pages as its second field
+func processRSS() map[string]float64 {
+	data, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return nil
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return nil
+	}
+	pages, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return nil
+	}
+	return map[string]float64{"": pages * float64(os.Getpagesize())}
+}
+
+func processFDs() map[string]float64 {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return nil
+	}
+	return map[string]float64{"": float64(len(fds))}
+}
@@ -0,0 +1,201 @@
+// Package namespace splits the jobs of a server into named namespaces,
+// e.g. one per team. each namespace has its own job ids, default
+// resource limits for its jobs, quotas and grants saying which client
+// identities may read or start jobs in it. namespaces are read from a
+// JSON file keyed by name.
+package namespace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/bradyfontenot/ljw/internal/quota"
+	"github.com/bradyfontenot/ljw/internal/worker"
+)
+
+// access levels of a grant. Write includes Read.
+const (
+	None  = ""
+	Read  = "read"
+	Write = "write"
+)
+
+var levels = map[string]int{None: 0, Read: 1, Write: 2}
+
+// validName is a lowercase DNS label, so names are safe in urls and
+// as directory names
+var validName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// Limits are the default resource limits of the jobs in a namespace.
+// zero fields are not enforced.
+type Limits struct {
+	// Timeout is a duration such as "1h"
+	Timeout     string  `json:"timeout,omitempty"`
+	CPUSeconds  float64 `json:"cpuSeconds,omitempty"`
+	MemoryBytes int64   `json:"memoryBytes,omitempty"`
+}
+
+// Config describes one namespace
+type Config struct {
+	// Grants maps identity globs to Read or Write. an identity gets the
+	// highest access of the globs it matches
+	Grants map[string]string `json:"grants,omitempty"`
+	Limits Limits            `json:"limits,omitempty"`
+	// Quotas replace the server's quotas for jobs in the namespace
+	Quotas *quota.Config `json:"quotas,omitempty"`
+}
+
+// Namespaces are the configured namespaces. the default namespace
+// always exists; until it is configured everyone may write to it.
+// the zero value has only the default namespace.
+type Namespaces struct {
+	spaces map[string]*space
+}
+
+type space struct {
+	cfg    Config
+	limits worker.Limits
+	quotas *quota.Quotas
+}
+
+// Load reads the namespaces in file
+func Load(file string) (*Namespaces, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg map[string]Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("could not parse namespaces %s. error: %w", file, err)
+	}
+	n, err := New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespaces %s. error: %w", file, err)
+	}
+	return n, nil
+}
+
+// New checks cfg, keyed by namespace name, and returns its Namespaces
+func New(cfg map[string]Config) (*Namespaces, error) {
+	n := &Namespaces{spaces: make(map[string]*space)}
+	for name, c := range cfg {
+		if !validName.MatchString(name) {
+			return nil, fmt.Errorf("%q is not a valid namespace name. use lowercase letters, digits and -", name)
+		}
+		for id, level := range c.Grants {
+			if _, err := path.Match(id, ""); err != nil {
+				return nil, fmt.Errorf("%s.grants: %q is not a valid pattern", name, id)
+			}
+			if level != Read && level != Write {
+				return nil, fmt.Errorf("%s.grants.%s must be %q or %q", name, id, Read, Write)
+			}
+		}
+
+		sp := &space{cfg: c}
+		var err error
+		if sp.limits, err = c.Limits.parse(name + ".limits"); err != nil {
+			return nil, err
+		}
+		if c.Quotas != nil {
+			if sp.quotas, err = quota.New(*c.Quotas); err != nil {
+				return nil, fmt.Errorf("%s.quotas.%w", name, err)
+			}
+		}
+		n.spaces[name] = sp
+	}
+	return n, nil
+}
+
+func (l Limits) parse(field string) (worker.Limits, error) {
+	var limits worker.Limits
+	if l.Timeout != "" {
+		d, err := time.ParseDuration(l.Timeout)
+		if err != nil || d < 0 {
+			return limits, fmt.Errorf("%s.timeout: %s is not a valid duration", field, l.Timeout)
+		}
+		limits.Timeout = d
+	}
+	switch {
+	case l.CPUSeconds < 0:
+		return limits, fmt.Errorf("%s.cpuSeconds must not be negative", field)
+	case l.MemoryBytes < 0:
+		return limits, fmt.Errorf("%s.memoryBytes must not be negative", field)
+	}
+	limits.CPU = time.Duration(l.CPUSeconds * float64(time.Second))
+	limits.Memory = l.MemoryBytes
+	return limits, nil
+}
+
+// Names returns the names of every namespace in order, with the
+// default namespace first
+func (n *Namespaces) Names() []string {
+	names := []string{worker.DefaultNamespace}
+	for name := range n.spaces {
+		if name != worker.DefaultNamespace {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// Exists reports whether ns is a namespace
+func (n *Namespaces) Exists(ns string) bool {
+	_, ok := n.spaces[ns]
+	return ok || ns == worker.DefaultNamespace
+}
+
+// Access returns the access identity has to ns, None if it has none
+// or ns doesn't exist
+func (n *Namespaces) Access(identity, ns string) string {
+	sp, ok := n.spaces[ns]
+	if !ok {
+		if ns == worker.DefaultNamespace {
+			return Write
+		}
+		return None
+	}
+
+	access := None
+	for id, level := range sp.cfg.Grants {
+		if ok, _ := path.Match(id, identity); ok && Allows(level, access) {
+			access = level
+		}
+	}
+	return access
+}
+
+// Allows reports whether access is at least level
+func Allows(access, level string) bool {
+	return levels[access] >= levels[level]
+}
+
+// Config returns the config of ns
+func (n *Namespaces) Config(ns string) Config {
+	if sp, ok := n.spaces[ns]; ok {
+		return sp.cfg
+	}
+	return Config{}
+}
+
+// Limits returns the resource limits of the jobs in ns
+func (n *Namespaces) Limits(ns string) worker.Limits {
+	if sp, ok := n.spaces[ns]; ok {
+		return sp.limits
+	}
+	return worker.Limits{}
+}
+
+// Quotas returns the quotas of ns, nil if it doesn't have its own
+func (n *Namespaces) Quotas(ns string) *quota.Quotas {
+	if sp, ok := n.spaces[ns]; ok {
+		return sp.quotas
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package namespace
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bradyfontenot/ljw/internal/quota"
+	"github.com/bradyfontenot/ljw/internal/worker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaces(t *testing.T) {
+	n, err := New(map[string]Config{
+		"infra": {
+			Grants: map[string]string{"ops-*": Write, "ops-intern": Read, "auditor": Read},
+			Limits: Limits{Timeout: "100ms", CPUSeconds: 1.5, MemoryBytes: 1 << 20},
+			Quotas: &quota.Config{Default: quota.Limits{MaxRunning: 1}},
+		},
+		"web": {
+			Grants: map[string]string{"dev": Write},
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t.Run("test names list the default namespace first", func(t *testing.T) {
+		assert.Equal(t, []string{"default", "infra", "web"}, n.Names())
+		assert.True(t, n.Exists("default"))
+		assert.True(t, n.Exists("web"))
+		assert.False(t, n.Exists("nope"))
+	})
+
+	t.Run("test access is the highest grant matched", func(t *testing.T) {
+		tests := []struct {
+			identity, ns, access string
+		}{
+			{"ops-1", "infra", Write},
+			{"ops-intern", "infra", Write},
+			{"auditor", "infra", Read},
+			{"dev", "infra", None},
+			{"dev", "web", Write},
+			{"dev", "nope", None},
+			{"anyone", "default", Write},
+		}
+		for _, tc := range tests {
+			assert.Equal(t, tc.access, n.Access(tc.identity, tc.ns), tc.identity+" in "+tc.ns)
+		}
+
+		assert.True(t, Allows(Write, Read))
+		assert.False(t, Allows(Read, Write))
+		assert.False(t, Allows(None, Read))
+	})
+
+	t.Run("test limits and quotas are parsed", func(t *testing.T) {
+		assert.Equal(t, worker.Limits{Timeout: 100 * time.Millisecond, CPU: 1500 * time.Millisecond, Memory: 1 << 20}, n.Limits("infra"))
+		assert.Equal(t, worker.Limits{}, n.Limits("web"))
+		if assert.NotNil(t, n.Quotas("infra")) {
+			assert.Equal(t, 1, n.Quotas("infra").Limits("ops-1").MaxRunning)
+		}
+		assert.Nil(t, n.Quotas("web"))
+	})
+
+	t.Run("test the zero value has the default namespace", func(t *testing.T) {
+		var zero Namespaces
+		assert.Equal(t, []string{"default"}, zero.Names())
+		assert.Equal(t, Write, zero.Access("anyone", "default"))
+	})
+}
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "namespaces.json")
+	ioutil.WriteFile(file, []byte(`{"default":{"grants":{"*":"read","ops":"write"}}}`), 0600)
+
+	n, err := Load(file)
+	if assert.NoError(t, err) {
+		assert.Equal(t, Read, n.Access("dev", "default"), "a configured default namespace is only open to its grants")
+		assert.Equal(t, Write, n.Access("ops", "default"))
+	}
+
+	t.Run("test invalid namespaces are rejected", func(t *testing.T) {
+		for cfg, msg := range map[string]string{
+			`{"Team A":{}}`:                              `"Team A" is not a valid namespace name. use lowercase letters, digits and -`,
+			`{"ops":{"grants":{"*":"admin"}}}`:           `ops.grants.* must be "read" or "write"`,
+			`{"ops":{"grants":{"[":"read"}}}`:            `ops.grants: "[" is not a valid pattern`,
+			`{"ops":{"limits":{"timeout":"soon"}}}`:      `ops.limits.timeout: soon is not a valid duration`,
+			`{"ops":{"limits":{"memoryBytes":-1}}}`:      `ops.limits.memoryBytes must not be negative`,
+			`{"ops":{"quotas":{"default":{"rate":-1}}}}`: `ops.quotas.default.rate must not be negative`,
+		} {
+			ioutil.WriteFile(file, []byte(cfg), 0600)
+			_, err := Load(file)
+			assert.EqualError(t, err, "invalid namespaces "+file+". error: "+msg, cfg)
+		}
+	})
+}
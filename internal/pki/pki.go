@@ -0,0 +1,422 @@
+// Package pki issues and revokes the certificates used for mutual TLS.
+// a directory holds the CA, every certificate issued by it and an
+// index of those certificates. revoked certificates are published in a
+// CRL signed by the CA.
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// file names inside a pki directory
+const (
+	CACertFile = "ca.crt"
+	CAKeyFile  = "ca.key"
+	CRLFile    = "ca.crl"
+	indexFile  = "index.json"
+)
+
+// certificate kinds
+const (
+	KindCA     = "ca"
+	KindServer = "server"
+	KindClient = "client"
+)
+
+// crlValidity is how long a CRL is valid before it must be re-signed
+const crlValidity = 7 * 24 * time.Hour
+
+// ErrExists is returned by Init when the directory already holds a CA
+var ErrExists = errors.New("a CA already exists")
+
+// Record is an issued certificate in the index
+type Record struct {
+	// Serial is the certificate serial number in hex
+	Serial    string    `json:"serial"`
+	Kind      string    `json:"kind"`
+	CN        string    `json:"cn"`
+	OU        []string  `json:"ou,omitempty"`
+	SANs      []string  `json:"sans,omitempty"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	// CertFile is relative to the pki directory
+	CertFile  string    `json:"certFile"`
+	RevokedAt time.Time `json:"revokedAt,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// Revoked reports whether the certificate has been revoked
+func (r Record) Revoked() bool {
+	return !r.RevokedAt.IsZero()
+}
+
+// PKI is a CA kept in a directory
+type PKI struct {
+	dir string
+}
+
+// New returns the PKI kept in dir
+func New(dir string) *PKI {
+	return &PKI{dir: dir}
+}
+
+func (p *PKI) path(name string) string {
+	return filepath.Join(p.dir, name)
+}
+
+// Init creates a self-signed CA valid for validity. it fails with
+// ErrExists if the directory already holds one, unless force is set.
+func (p *PKI) Init(cn string, validity time.Duration, force bool) (Record, error) {
+	if _, err := os.Stat(p.path(CACertFile)); err == nil && !force {
+		return Record{}, ErrExists
+	}
+	if err := os.MkdirAll(p.dir, 0700); err != nil {
+		return Record{}, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return Record{}, err
+	}
+	tmpl, err := template(cn, nil, validity)
+	if err != nil {
+		return Record{}, err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.MaxPathLenZero = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return Record{}, err
+	}
+	if err := writeKey(p.path(CAKeyFile), key); err != nil {
+		return Record{}, err
+	}
+	if err := writeCert(p.path(CACertFile), der); err != nil {
+		return Record{}, err
+	}
+
+	// a new CA starts a new index
+	rec := record(KindCA, CACertFile, tmpl)
+	if err := p.saveIndex([]Record{rec}); err != nil {
+		return Record{}, err
+	}
+	return rec, p.writeCRL(nil)
+}
+
+// IssueServer issues a server certificate for the hosts and ips in sans.
+// the certificate and key are written to name.crt and name.key.
+func (p *PKI) IssueServer(name string, sans []string, validity time.Duration) (Record, error) {
+	if len(sans) == 0 {
+		return Record{}, errors.New("a server certificate needs at least one SAN")
+	}
+
+	tmpl, err := template(sans[0], nil, validity)
+	if err != nil {
+		return Record{}, err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, san)
+		}
+	}
+
+	rec, err := p.issue(KindServer, name, tmpl)
+	rec.SANs = sans
+	if err != nil {
+		return rec, err
+	}
+	return rec, p.update(func(index []Record) []Record { return append(index, rec) })
+}
+
+// IssueClient issues a client certificate with common name cn, which
+// becomes the client's identity, and organizational units ou.
+// the certificate and key are written to name.crt and name.key.
+func (p *PKI) IssueClient(name, cn string, ou []string, validity time.Duration) (Record, error) {
+	if cn == "" {
+		return Record{}, errors.New("a client certificate needs a common name")
+	}
+
+	tmpl, err := template(cn, ou, validity)
+	if err != nil {
+		return Record{}, err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	rec, err := p.issue(KindClient, name, tmpl)
+	if err != nil {
+		return rec, err
+	}
+	return rec, p.update(func(index []Record) []Record { return append(index, rec) })
+}
+
+// issue signs tmpl with the CA and writes the certificate and a new key
+func (p *PKI) issue(kind, name string, tmpl *x509.Certificate) (Record, error) {
+	ca, caKey, err := p.loadCA()
+	if err != nil {
+		return Record{}, err
+	}
+	if tmpl.NotAfter.After(ca.NotAfter) {
+		return Record{}, fmt.Errorf("certificate would outlive the CA, which expires %s", ca.NotAfter.Format(time.RFC3339))
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return Record{}, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, key.Public(), caKey)
+	if err != nil {
+		return Record{}, err
+	}
+
+	certFile := name + ".crt"
+	if err := writeKey(p.path(name+".key"), key); err != nil {
+		return Record{}, err
+	}
+	if err := writeCert(p.path(certFile), der); err != nil {
+		return Record{}, err
+	}
+	return record(kind, certFile, tmpl), nil
+}
+
+// List returns the index of issued certificates, oldest first
+func (p *PKI) List() ([]Record, error) {
+	return p.loadIndex()
+}
+
+// Revoke revokes the certificate with serial and re-signs the CRL
+func (p *PKI) Revoke(serial, reason string) (Record, error) {
+	var revoked Record
+	err := p.update(func(index []Record) []Record {
+		for i := range index {
+			if index[i].Serial == serial && index[i].Kind != KindCA {
+				if !index[i].Revoked() {
+					index[i].RevokedAt = time.Now().UTC()
+					index[i].Reason = reason
+				}
+				revoked = index[i]
+			}
+		}
+		return index
+	})
+	if err != nil {
+		return revoked, err
+	}
+	if revoked.Serial == "" {
+		return revoked, fmt.Errorf("no certificate with serial %s", serial)
+	}
+	return revoked, p.WriteCRL()
+}
+
+// WriteCRL signs a new CRL listing every revoked certificate. CRLs
+// expire after a week, so this must be run again before then.
+func (p *PKI) WriteCRL() error {
+	index, err := p.loadIndex()
+	if err != nil {
+		return err
+	}
+	return p.writeCRL(index)
+}
+
+func (p *PKI) writeCRL(index []Record) error {
+	ca, caKey, err := p.loadCA()
+	if err != nil {
+		return err
+	}
+
+	var revoked []pkix.RevokedCertificate
+	for _, rec := range index {
+		if !rec.Revoked() {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(rec.Serial, 16)
+		if !ok {
+			return fmt.Errorf("bad serial %q in index", rec.Serial)
+		}
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: serial, RevocationTime: rec.RevokedAt})
+	}
+
+	now := time.Now().UTC()
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificates: revoked,
+		Number:              big.NewInt(now.UnixNano()),
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(crlValidity),
+	}, ca, caKey)
+	if err != nil {
+		return err
+	}
+	return writeFile(p.path(CRLFile), pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0644)
+}
+
+// loadCA reads the CA certificate and key
+func (p *PKI) loadCA() (*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := ioutil.ReadFile(p.path(CACertFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read CA. run init first. error: %w", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no certificate in %s", p.path(CACertFile))
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM, err := ioutil.ReadFile(p.path(CAKeyFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no key in %s", p.path(CAKeyFile))
+	}
+	key, err := parseKey(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// parseKey parses the private key formats openssl and Init write
+func parseKey(block *pem.Block) (crypto.Signer, error) {
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("CA key can't sign")
+	}
+	return signer, nil
+}
+
+func (p *PKI) loadIndex() ([]Record, error) {
+	data, err := ioutil.ReadFile(p.path(indexFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var index []Record
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("could not parse %s. error: %w", p.path(indexFile), err)
+	}
+	sort.SliceStable(index, func(i, j int) bool { return index[i].NotBefore.Before(index[j].NotBefore) })
+	return index, nil
+}
+
+func (p *PKI) saveIndex(index []Record) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(p.path(indexFile), append(data, '\n'), 0600)
+}
+
+// update applies fn to the index and saves the result
+func (p *PKI) update(fn func([]Record) []Record) error {
+	index, err := p.loadIndex()
+	if err != nil {
+		return err
+	}
+	return p.saveIndex(fn(index))
+}
+
+// template returns a certificate template with a random serial
+func template(cn string, ou []string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, err
+	}
+
+	// backdate a little to allow for clock skew
+	now := time.Now().UTC().Truncate(time.Second)
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn, OrganizationalUnit: ou},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+func record(kind, certFile string, cert *x509.Certificate) Record {
+	return Record{
+		Serial:    cert.SerialNumber.Text(16),
+		Kind:      kind,
+		CN:        cert.Subject.CommonName,
+		OU:        cert.Subject.OrganizationalUnit,
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		CertFile:  certFile,
+	}
+}
+
+func writeKey(file string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writeFile(file, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+}
+
+func writeCert(file string, der []byte) error {
+	return writeFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// writeFile writes data to a temp file and renames it over file so
+// readers never see a partial file
+func writeFile(file string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
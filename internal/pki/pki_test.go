@@ -0,0 +1,117 @@
+package pki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPKI(t *testing.T) {
+	dir := t.TempDir()
+	p := New(dir)
+
+	ca, err := p.Init("test CA", 24*time.Hour, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = p.Init("test CA", 24*time.Hour, false)
+	assert.ErrorIs(t, err, ErrExists)
+
+	_, err = p.IssueServer("server", []string{"localhost", "127.0.0.1"}, time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+	alice, err := p.IssueClient("alice", "alice", []string{"dev"}, time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = p.IssueClient("bob", "bob", nil, 48*time.Hour)
+	assert.Error(t, err, "a certificate can't outlive the CA")
+
+	t.Run("test issued certs authenticate", func(t *testing.T) {
+		caCert, err := ioutil.ReadFile(filepath.Join(dir, CACertFile))
+		if err != nil {
+			log.Fatal(err)
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(caCert)
+		srvCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		cliCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "alice.crt"), filepath.Join(dir, "alice.key"))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cert := r.TLS.PeerCertificates[0]
+			fmt.Fprint(w, cert.Subject.CommonName+" "+strings.Join(cert.Subject.OrganizationalUnit, ","))
+		}))
+		s.TLS = &tls.Config{
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pool,
+			Certificates: []tls.Certificate{srvCert},
+		}
+		s.StartTLS()
+		defer s.Close()
+
+		cl := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      pool,
+			Certificates: []tls.Certificate{cliCert},
+		}}}
+		res, err := cl.Get(strings.Replace(s.URL, "127.0.0.1", "localhost", 1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		assert.Equal(t, "alice dev", string(body))
+	})
+
+	t.Run("test revoke adds serial to CRL", func(t *testing.T) {
+		_, err := p.Revoke(alice.Serial, "left team")
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = p.Revoke("abc", "")
+		assert.Error(t, err)
+
+		index, err := p.List()
+		if err != nil {
+			log.Fatal(err)
+		}
+		assert.Len(t, index, 3)
+		for _, rec := range index {
+			assert.Equal(t, rec.Serial == alice.Serial, rec.Revoked(), rec.CN)
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, CRLFile))
+		if err != nil {
+			log.Fatal(err)
+		}
+		crl, err := x509.ParseCRL(data)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if assert.Len(t, crl.TBSCertList.RevokedCertificates, 1) {
+			assert.Equal(t, alice.Serial, crl.TBSCertList.RevokedCertificates[0].SerialNumber.Text(16))
+		}
+	})
+
+	t.Run("test init with force replaces the CA", func(t *testing.T) {
+		renewed, err := p.Init("test CA", 24*time.Hour, true)
+		assert.NoError(t, err)
+		assert.NotEqual(t, ca.Serial, renewed.Serial)
+	})
+}
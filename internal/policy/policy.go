@@ -0,0 +1,231 @@
+// Package policy decides which commands a client may run. a policy is
+// a JSON file of allow and deny rules matched against the resolved
+// executable path, the arguments and the identity of the client.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// actions a rule can take
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// Rule matches a command. empty fields match everything.
+type Rule struct {
+	// Action is Allow or Deny
+	Action string `json:"action"`
+	// Path is a glob matched against the absolute path of the
+	// executable, as resolved through PATH
+	Path string `json:"path,omitempty"`
+	// Args is a regular expression matched against the arguments
+	// after the executable, joined by single spaces
+	Args string `json:"args,omitempty"`
+	// Identities are globs matched against the client identity
+	Identities []string `json:"identities,omitempty"`
+
+	args *regexp.Regexp
+}
+
+// Override replaces the default and adds rules for one identity.
+// its rules are checked before the policy's own.
+type Override struct {
+	Default string `json:"default,omitempty"`
+	Rules   []Rule `json:"rules,omitempty"`
+}
+
+// Policy is a set of rules. the first rule matching a command decides
+// whether it may run. if none match, Default does.
+type Policy struct {
+	// Default is Allow or Deny. defaults to Deny
+	Default string `json:"default,omitempty"`
+	Rules   []Rule `json:"rules"`
+	// Identities holds per-identity overrides keyed by exact identity
+	Identities map[string]Override `json:"identities,omitempty"`
+}
+
+// DeniedError is returned by Check for a command the policy doesn't allow
+type DeniedError struct {
+	Identity string
+	// Path is the resolved executable, or the command as given if it
+	// couldn't be resolved
+	Path   string
+	Reason string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("%s is not allowed to run %s: %s", identityName(e.Identity), e.Path, e.Reason)
+}
+
+// Load reads and compiles the policy in file
+func Load(file string) (*Policy, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("could not parse policy %s. error: %w", file, err)
+	}
+	if err := p.compile(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s. error: %w", file, err)
+	}
+	return &p, nil
+}
+
+// compile checks every rule and compiles its args pattern
+func (p *Policy) compile() error {
+	if err := checkDefault(p.Default); err != nil {
+		return err
+	}
+	if err := compileRules(p.Rules, "rules"); err != nil {
+		return err
+	}
+	for id, o := range p.Identities {
+		if err := checkDefault(o.Default); err != nil {
+			return fmt.Errorf("identities.%s: %w", id, err)
+		}
+		if err := compileRules(o.Rules, "identities."+id+".rules"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkDefault(action string) error {
+	if action != "" && action != Allow && action != Deny {
+		return fmt.Errorf("default must be %q or %q, not %q", Allow, Deny, action)
+	}
+	return nil
+}
+
+func compileRules(rules []Rule, field string) error {
+	for i := range rules {
+		r := &rules[i]
+		where := fmt.Sprintf("%s[%d]", field, i)
+
+		if r.Action != Allow && r.Action != Deny {
+			return fmt.Errorf("%s: action must be %q or %q, not %q", where, Allow, Deny, r.Action)
+		}
+		if _, err := path.Match(r.Path, ""); err != nil {
+			return fmt.Errorf("%s: bad path glob %q", where, r.Path)
+		}
+		for _, id := range r.Identities {
+			if _, err := path.Match(id, ""); err != nil {
+				return fmt.Errorf("%s: bad identity glob %q", where, id)
+			}
+		}
+		if r.Args != "" {
+			re, err := regexp.Compile(r.Args)
+			if err != nil {
+				return fmt.Errorf("%s: bad args pattern. error: %w", where, err)
+			}
+			r.args = re
+		}
+	}
+	return nil
+}
+
+// Check returns nil if identity may run cmd in dir, otherwise a
+// *DeniedError. the executable is resolved through PATH, or against
+// dir if it is a relative path, so rules always see an absolute path
+// to what will run. executables that can't be resolved are denied.
+func (p *Policy) Check(identity string, cmd []string, dir string) error {
+	if len(cmd) == 0 {
+		return &DeniedError{Identity: identity, Reason: "no command"}
+	}
+
+	exe, err := resolve(cmd[0], dir)
+	if err != nil {
+		return &DeniedError{Identity: identity, Path: cmd[0], Reason: "executable not found"}
+	}
+	args := strings.Join(cmd[1:], " ")
+
+	def := p.Default
+	if o, ok := p.Identities[identity]; ok {
+		if i, r := match(o.Rules, identity, exe, args); r != nil {
+			return decide(r.Action, identity, exe, fmt.Sprintf("identities.%s.rules[%d]", identity, i))
+		}
+		if o.Default != "" {
+			def = o.Default
+		}
+	}
+
+	if i, r := match(p.Rules, identity, exe, args); r != nil {
+		return decide(r.Action, identity, exe, fmt.Sprintf("rules[%d]", i))
+	}
+
+	if def == "" {
+		def = Deny
+	}
+	return decide(def, identity, exe, "default")
+}
+
+// match returns the first rule matching the command and its index
+func match(rules []Rule, identity, exe, args string) (int, *Rule) {
+	for i := range rules {
+		if rules[i].matches(identity, exe, args) {
+			return i, &rules[i]
+		}
+	}
+	return -1, nil
+}
+
+func (r *Rule) matches(identity, exe, args string) bool {
+	if r.Path != "" {
+		if ok, _ := path.Match(r.Path, exe); !ok {
+			return false
+		}
+	}
+	if r.args != nil && !r.args.MatchString(args) {
+		return false
+	}
+	if len(r.Identities) == 0 {
+		return true
+	}
+	for _, id := range r.Identities {
+		if ok, _ := path.Match(id, identity); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// decide turns action into Check's result. by names what decided.
+func decide(action, identity, exe, by string) error {
+	if action == Allow {
+		return nil
+	}
+	return &DeniedError{Identity: identity, Path: exe, Reason: "denied by " + by}
+}
+
+// resolve finds the absolute path of executable name. a relative path
+// such as ./tool runs from the job's working directory dir, so it is
+// resolved there. with dir "" that is the server's.
+func resolve(name, dir string) (string, error) {
+	if dir != "" && !filepath.IsAbs(name) && strings.ContainsRune(name, filepath.Separator) {
+		name = filepath.Join(dir, name)
+	}
+	exe, err := exec.LookPath(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(exe)
+}
+
+func identityName(identity string) string {
+	if identity == "" {
+		return "anonymous client"
+	}
+	return identity
+}
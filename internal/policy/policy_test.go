@@ -0,0 +1,106 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	echo, _ := exec.LookPath("echo")
+	sleep, _ := exec.LookPath("sleep")
+	// tools in tools/bin may be run, ones elsewhere may not
+	root := t.TempDir()
+	for _, dir := range []string{"tools/bin", "other/bin"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0700); err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, dir, "tool"), []byte("#!/bin/sh\n"), 0700); err != nil {
+			log.Fatal(err)
+		}
+	}
+	file := filepath.Join(t.TempDir(), "policy.json")
+	rules := fmt.Sprintf(`{
+		"default": "deny",
+		"rules": [
+			{"action": "deny", "path": %q, "args": "(^| )secret( |$)"},
+			{"action": "allow", "path": %q},
+			{"action": "allow", "path": %q, "identities": ["ops-*"]},
+			{"action": "allow", "path": %q}
+		],
+		"identities": {"admin": {"default": "allow"}}
+	}`, echo, echo, sleep, filepath.Join(root, "tools/bin/*"))
+	if err := ioutil.WriteFile(file, []byte(rules), 0600); err != nil {
+		log.Fatal(err)
+	}
+
+	p, err := Load(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		identity string
+		cmd      []string
+		dir      string
+		reason   string
+	}{
+		{"allowed path", "alice", []string{"echo", "hello"}, "", ""},
+		{"denied args", "alice", []string{"echo", "the", "secret"}, "", "denied by rules[0]"},
+		{"default deny", "alice", []string{"sleep", "0"}, "", "denied by default"},
+		{"allowed identity", "ops-1", []string{"sleep", "0"}, "", ""},
+		{"identity override", "admin", []string{"true"}, "", ""},
+		{"unresolvable executable", "admin", []string{"no-such-command"}, "", "executable not found"},
+		{"no command", "admin", nil, "", "no command"},
+		{"relative executable in dir", "alice", []string{"bin/tool"}, filepath.Join(root, "tools"), ""},
+		{"relative executable elsewhere", "alice", []string{"bin/tool"}, filepath.Join(root, "other"), "denied by default"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := p.Check(tc.identity, tc.cmd, tc.dir)
+			if tc.reason == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var denied *DeniedError
+			if assert.True(t, errors.As(err, &denied), "error should be a *DeniedError") {
+				assert.Equal(t, tc.identity, denied.Identity)
+				assert.Equal(t, tc.reason, denied.Reason)
+			}
+		})
+	}
+
+	t.Run("relative executables are resolved against dir", func(t *testing.T) {
+		err := p.Check("alice", []string{"bin/tool"}, filepath.Join(root, "other"))
+		assert.EqualError(t, err, "alice is not allowed to run "+filepath.Join(root, "other/bin/tool")+": denied by default")
+	})
+}
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.json")
+
+	for rules, msg := range map[string]string{
+		`{"rules":[{"action":"maybe"}]}`:                    `rules[0]: action must be "allow" or "deny", not "maybe"`,
+		`{"default":"sometimes","rules":[]}`:                `default must be "allow" or "deny", not "sometimes"`,
+		`{"rules":[{"action":"allow","path":"["}]}`:         `rules[0]: bad path glob "["`,
+		`{"identities":{"ops":{"rules":[{"action":"x"}]}}}`: `identities.ops.rules[0]: action must be "allow" or "deny", not "x"`,
+		`{"rules":[{"action":"deny","identities":["[a"]}]}`: `rules[0]: bad identity glob "[a"`,
+	} {
+		ioutil.WriteFile(file, []byte(rules), 0600)
+		_, err := Load(file)
+		assert.EqualError(t, err, "invalid policy "+file+". error: "+msg, rules)
+	}
+
+	ioutil.WriteFile(file, []byte(`{`), 0600)
+	_, err := Load(file)
+	assert.Error(t, err)
+}
@@ -0,0 +1,232 @@
+// Package quota limits how much of the server each client identity may
+// use: how fast it may call the API, how many jobs it may have running
+// and queued, and how much cpu time its jobs may use per day. limits
+// are read from a JSON file with defaults and per-identity overrides.
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sync"
+	"time"
+)
+
+// names of the quotas reported in an ExceededError
+const (
+	Rate    = "rate"
+	Running = "running"
+	Queued  = "queued"
+	CPU     = "cpu"
+)
+
+// busyRetry is how long clients are told to wait when they have too
+// many jobs running or queued. there's no telling when one will end.
+const busyRetry = 5 * time.Second
+
+// Limits are the quotas of one identity. zero fields are not enforced,
+// except MaxQueued.
+type Limits struct {
+	// Rate is the API calls allowed per second on average
+	Rate float64 `json:"rate,omitempty"`
+	// Burst is how many calls may be made at once. defaults to Rate
+	// rounded up
+	Burst int `json:"burst,omitempty"`
+	// MaxRunning caps the identity's jobs that are running
+	MaxRunning int `json:"maxRunning,omitempty"`
+	// MaxQueued is how many more jobs may wait to start once MaxRunning
+	// are running. with zero they are turned away instead
+	MaxQueued int `json:"maxQueued,omitempty"`
+	// CPUSecondsPerDay caps the cpu time used by the identity's jobs
+	// that ended in the current UTC day
+	CPUSecondsPerDay float64 `json:"cpuSecondsPerDay,omitempty"`
+}
+
+// Config holds the default limits and overrides for single identities.
+// an override replaces the defaults as a whole.
+type Config struct {
+	Default    Limits            `json:"default"`
+	Identities map[string]Limits `json:"identities,omitempty"`
+}
+
+// Usage is what an identity is using when it starts a job
+type Usage struct {
+	Running    int
+	Queued     int
+	CPUSeconds float64
+	// CPUReset is when CPUSeconds goes back to zero
+	CPUReset time.Time
+}
+
+// ExceededError is returned for a call or job that is over a quota
+type ExceededError struct {
+	Identity string
+	// Quota is Rate, Running, Queued or CPU
+	Quota string
+	Limit float64
+	// RetryAfter is how long until trying again could succeed
+	RetryAfter time.Duration
+}
+
+func (e *ExceededError) Error() string {
+	switch e.Quota {
+	case Rate:
+		return fmt.Sprintf("%s is over the rate limit of %g calls per second", identityName(e.Identity), e.Limit)
+	case Running:
+		return fmt.Sprintf("%s has reached its limit of %g running jobs", identityName(e.Identity), e.Limit)
+	case Queued:
+		return fmt.Sprintf("%s has reached its limit of %g queued jobs", identityName(e.Identity), e.Limit)
+	default:
+		return fmt.Sprintf("%s has used its %g cpu seconds for today", identityName(e.Identity), e.Limit)
+	}
+}
+
+func identityName(id string) string {
+	if id == "" {
+		return "anonymous client"
+	}
+	return id
+}
+
+// Quotas enforces a Config. it keeps a token bucket per identity for
+// the rate limit. job quotas are checked against usage from the worker.
+type Quotas struct {
+	cfg Config
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucket holds the calls an identity has left. it refills at the
+// identity's rate up to its burst.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Load reads the config in file
+func Load(file string) (*Quotas, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("could not parse quotas %s. error: %w", file, err)
+	}
+	q, err := New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quotas %s. error: %w", file, err)
+	}
+	return q, nil
+}
+
+// New checks cfg and returns Quotas enforcing it
+func New(cfg Config) (*Quotas, error) {
+	if err := cfg.Default.check("default"); err != nil {
+		return nil, err
+	}
+	for id, l := range cfg.Identities {
+		if err := l.check("identities." + id); err != nil {
+			return nil, err
+		}
+	}
+	return &Quotas{cfg: cfg, buckets: make(map[string]*bucket)}, nil
+}
+
+func (l Limits) check(field string) error {
+	switch {
+	case l.Rate < 0:
+		return fmt.Errorf("%s.rate must not be negative", field)
+	case l.Burst < 0:
+		return fmt.Errorf("%s.burst must not be negative", field)
+	case l.MaxRunning < 0:
+		return fmt.Errorf("%s.maxRunning must not be negative", field)
+	case l.MaxQueued < 0:
+		return fmt.Errorf("%s.maxQueued must not be negative", field)
+	case l.CPUSecondsPerDay < 0:
+		return fmt.Errorf("%s.cpuSecondsPerDay must not be negative", field)
+	}
+	return nil
+}
+
+// Limits returns the limits of identity
+func (q *Quotas) Limits(identity string) Limits {
+	l, ok := q.cfg.Identities[identity]
+	if !ok {
+		l = q.cfg.Default
+	}
+	if l.Rate > 0 && l.Burst == 0 {
+		l.Burst = int(math.Ceil(l.Rate))
+	}
+	return l
+}
+
+// Allow takes one call from identity's bucket. it returns an
+// *ExceededError if the bucket is empty.
+func (q *Quotas) Allow(identity string) error {
+	l := q.Limits(identity)
+	if l.Rate == 0 {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	b := q.fill(identity, l)
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+		return &ExceededError{Identity: identity, Quota: Rate, Limit: l.Rate, RetryAfter: wait}
+	}
+	b.tokens--
+	return nil
+}
+
+// Remaining returns the calls identity can make right now, or -1 if
+// it has no rate limit
+func (q *Quotas) Remaining(identity string) int {
+	l := q.Limits(identity)
+	if l.Rate == 0 {
+		return -1
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return int(q.fill(identity, l).tokens)
+}
+
+// fill tops up identity's bucket for the time since it was last used.
+// caller must hold the lock.
+func (q *Quotas) fill(identity string, l Limits) *bucket {
+	now := time.Now()
+	b, ok := q.buckets[identity]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		q.buckets[identity] = b
+	}
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	return b
+}
+
+// CheckStart returns an *ExceededError if identity, using u, can't
+// start another job. a job over MaxRunning that may still be queued is
+// allowed; the worker holds it until one of the running jobs ends. a job
+// only starts right away if none are queued, so jobs start in the order
+// they were submitted.
+func (q *Quotas) CheckStart(identity string, u Usage) error {
+	l := q.Limits(identity)
+	switch {
+	case l.CPUSecondsPerDay > 0 && u.CPUSeconds >= l.CPUSecondsPerDay:
+		return &ExceededError{Identity: identity, Quota: CPU, Limit: l.CPUSecondsPerDay, RetryAfter: time.Until(u.CPUReset)}
+	case l.MaxRunning == 0 || (u.Running < l.MaxRunning && u.Queued == 0):
+		return nil
+	case l.MaxQueued == 0:
+		return &ExceededError{Identity: identity, Quota: Running, Limit: float64(l.MaxRunning), RetryAfter: busyRetry}
+	case u.Queued >= l.MaxQueued:
+		return &ExceededError{Identity: identity, Quota: Queued, Limit: float64(l.MaxQueued), RetryAfter: busyRetry}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package quota
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllow(t *testing.T) {
+	q, err := New(Config{
+		Identities: map[string]Limits{
+			"chatty": {Rate: 1, Burst: 2},
+			"steady": {Rate: 2.5},
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t.Run("test calls over the burst are turned away", func(t *testing.T) {
+		assert.Equal(t, 2, q.Remaining("chatty"))
+		assert.NoError(t, q.Allow("chatty"))
+		assert.NoError(t, q.Allow("chatty"))
+		assert.Equal(t, 0, q.Remaining("chatty"))
+
+		err := q.Allow("chatty")
+		var qe *ExceededError
+		if assert.True(t, errors.As(err, &qe)) {
+			assert.Equal(t, Rate, qe.Quota)
+			assert.Equal(t, 1.0, qe.Limit)
+			assert.InDelta(t, time.Second.Seconds(), qe.RetryAfter.Seconds(), 0.1)
+		}
+		assert.EqualError(t, err, "chatty is over the rate limit of 1 calls per second")
+	})
+
+	t.Run("test burst defaults to the rate rounded up", func(t *testing.T) {
+		assert.Equal(t, 3, q.Limits("steady").Burst)
+		assert.Equal(t, 3, q.Remaining("steady"))
+	})
+
+	t.Run("test identities without a rate aren't limited", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, q.Allow("quiet"))
+		}
+		assert.Equal(t, -1, q.Remaining("quiet"))
+	})
+}
+
+func TestCheckStart(t *testing.T) {
+	reset := time.Now().Add(time.Hour)
+	q, err := New(Config{
+		Default: Limits{MaxRunning: 1},
+		Identities: map[string]Limits{
+			"patient":  {MaxRunning: 1, MaxQueued: 1},
+			"cruncher": {CPUSecondsPerDay: 10},
+			"free":     {},
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		identity string
+		usage    Usage
+		quota    string
+	}{
+		{"under the running quota", "busy", Usage{}, ""},
+		{"over the running quota", "busy", Usage{Running: 1}, Running},
+		{"over the running quota may queue", "patient", Usage{Running: 1}, ""},
+		{"over the queued quota", "patient", Usage{Running: 1, Queued: 1}, Queued},
+		// a slot that frees up goes to the queued job, not a new one
+		{"free slot with a job queued", "patient", Usage{Queued: 1}, Queued},
+		{"under the cpu quota", "cruncher", Usage{CPUSeconds: 9, CPUReset: reset}, ""},
+		{"over the cpu quota", "cruncher", Usage{CPUSeconds: 10, CPUReset: reset}, CPU},
+		{"override without limits", "free", Usage{Running: 100}, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := q.CheckStart(tc.identity, tc.usage)
+			if tc.quota == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var qe *ExceededError
+			if assert.True(t, errors.As(err, &qe)) {
+				assert.Equal(t, tc.quota, qe.Quota)
+				assert.Equal(t, tc.identity, qe.Identity)
+			}
+		})
+	}
+
+	t.Run("test the cpu quota is retried once it resets", func(t *testing.T) {
+		err := q.CheckStart("cruncher", Usage{CPUSeconds: 10, CPUReset: reset})
+		var qe *ExceededError
+		if assert.True(t, errors.As(err, &qe)) {
+			assert.InDelta(t, time.Hour.Seconds(), qe.RetryAfter.Seconds(), 1)
+		}
+	})
+}
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "quotas.json")
+	ioutil.WriteFile(file, []byte(`{"default":{"rate":5},"identities":{"ci":{"maxRunning":2}}}`), 0600)
+
+	q, err := Load(file)
+	if assert.NoError(t, err) {
+		assert.Equal(t, Limits{Rate: 5, Burst: 5}, q.Limits("dev"))
+		assert.Equal(t, Limits{MaxRunning: 2}, q.Limits("ci"), "an override replaces the defaults")
+	}
+
+	t.Run("test invalid quotas are rejected", func(t *testing.T) {
+		_, err := New(Config{Default: Limits{MaxRunning: -1}})
+		assert.EqualError(t, err, "default.maxRunning must not be negative")
+		_, err = New(Config{Identities: map[string]Limits{"ci": {CPUSecondsPerDay: -1}}})
+		assert.EqualError(t, err, "identities.ci.cpuSecondsPerDay must not be negative")
+
+		ioutil.WriteFile(file, []byte(`{"default":{"burst":-1}}`), 0600)
+		_, err = Load(file)
+		assert.EqualError(t, err, "invalid quotas "+file+". error: default.burst must not be negative")
+	})
+}
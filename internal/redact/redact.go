@@ -0,0 +1,144 @@
+// Package redact hides credentials in job output before it is stored.
+// rules are regular expressions read from a JSON file; each match is
+// replaced and counted so callers can tell how much was hidden.
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Replacement is what a match is replaced with unless its rule says
+// otherwise
+const Replacement = "[REDACTED]"
+
+// SecretRule names the rule hiding the values of a job's secrets
+const SecretRule = "secret"
+
+// Rule describes text to hide
+type Rule struct {
+	// Name identifies the rule in metrics
+	Name string `json:"name"`
+	// Pattern is a regular expression matched against each line
+	Pattern string `json:"pattern"`
+	// Replacement defaults to Replacement. it may refer to groups of
+	// the pattern as $1 or ${name}, e.g. to keep a key and hide its value
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// Rules are compiled rules applied in order. a nil *Rules hides nothing.
+type Rules struct {
+	rules []rule
+}
+
+type rule struct {
+	name        string
+	re          *regexp.Regexp
+	replacement string
+	// literal replacements don't expand $ references
+	literal bool
+}
+
+// Load reads the rules in file, a JSON list of Rule
+func Load(file string) (*Rules, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Rule
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("could not parse redaction rules %s. error: %w", file, err)
+	}
+	r, err := New(list)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redaction rules %s. error: %w", file, err)
+	}
+	return r, nil
+}
+
+// New compiles list
+func New(list []Rule) (*Rules, error) {
+	r := &Rules{}
+	for i, l := range list {
+		if l.Name == "" || l.Name == SecretRule {
+			return nil, fmt.Errorf("rule %d must have a name other than %q", i, SecretRule)
+		}
+		re, err := regexp.Compile(l.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", l.Name, err)
+		}
+		// a pattern matching "" would insert the replacement everywhere
+		if re.MatchString("") {
+			return nil, fmt.Errorf("%s: pattern must not match empty text", l.Name)
+		}
+		if l.Replacement == "" {
+			l.Replacement = Replacement
+		}
+		r.rules = append(r.rules, rule{name: l.Name, re: re, replacement: l.Replacement})
+	}
+	return r, nil
+}
+
+// WithValues returns r with a rule hiding each value first. output is
+// read a line at a time, so each line of a multi-line value is hidden
+// on its own. r itself is left alone.
+func (r *Rules) WithValues(values []string) *Rules {
+	var lines []string
+	for _, v := range values {
+		for _, line := range strings.Split(v, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return r
+	}
+
+	// longest first so a value containing another is hidden whole
+	sort.Slice(lines, func(i, j int) bool { return len(lines[i]) > len(lines[j]) })
+	for i := range lines {
+		lines[i] = regexp.QuoteMeta(lines[i])
+	}
+	out := &Rules{rules: []rule{{
+		name:        SecretRule,
+		re:          regexp.MustCompile(strings.Join(lines, "|")),
+		replacement: Replacement,
+		literal:     true,
+	}}}
+	if r != nil {
+		out.rules = append(out.rules, r.rules...)
+	}
+	return out
+}
+
+// Apply returns line with every rule applied and how many matches each
+// rule replaced, keyed by rule name. counts is nil if nothing matched.
+func (r *Rules) Apply(line string) (string, map[string]int) {
+	if r == nil {
+		return line, nil
+	}
+
+	var counts map[string]int
+	for _, l := range r.rules {
+		n := len(l.re.FindAllStringIndex(line, -1))
+		if n == 0 {
+			continue
+		}
+		if counts == nil {
+			counts = make(map[string]int)
+		}
+		counts[l.name] += n
+		if l.literal {
+			line = l.re.ReplaceAllLiteralString(line, l.replacement)
+		} else {
+			line = l.re.ReplaceAllString(line, l.replacement)
+		}
+	}
+	return line, counts
+}
@@ -0,0 +1,284 @@
+// Package secrets stores values such as tokens that jobs need but that
+// must not show up in commands, logs or API responses. values are
+// encrypted with AES-256-GCM under a master key read from a file, both
+// in the file the store is saved to and in memory. secrets belong to a
+// namespace.
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+)
+
+// ErrNotFound is returned for a secret that doesn't exist
+var ErrNotFound = errors.New("secret not found")
+
+// MaxValue is the largest value a secret may hold
+const MaxValue = 64 << 10
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+
+// entry is a secret as it is kept and saved. Value is the nonce
+// followed by the sealed value.
+type entry struct {
+	Name      string    `json:"name"`
+	Namespace string    `json:"namespace"`
+	Owner     string    `json:"owner"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+	Value     []byte    `json:"value"`
+}
+
+// Store holds the secrets of every namespace
+type Store struct {
+	mu   sync.RWMutex
+	aead cipher.AEAD
+	// file is where secrets are saved. "" keeps them in memory only
+	file string
+	// keyed by namespace and then name
+	secrets map[string]map[string]entry
+}
+
+// LoadKey reads a master key from file. the file holds 32 bytes written
+// as 64 hex digits, e.g. made with `openssl rand -hex 32`.
+func LoadKey(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s must hold a 32 byte key as 64 hex digits", file)
+	}
+	return key, nil
+}
+
+// Open returns the store kept in file, sealed with key. with file ""
+// secrets are only kept in memory.
+func Open(file string, key []byte) (*Store, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{aead: aead, file: file, secrets: make(map[string]map[string]entry)}
+	if file == "" {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []entry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("could not parse secrets %s. error: %w", file, err)
+	}
+	for _, e := range list {
+		// a wrong master key is caught here rather than when a job starts
+		if _, err := s.open(e); err != nil {
+			return nil, fmt.Errorf("could not decrypt secret %s/%s. is it the right key? error: %w", e.Namespace, e.Name, err)
+		}
+		s.put(e)
+	}
+	return s, nil
+}
+
+// Check returns what is wrong with a secret name and value by field,
+// or nil if they are fine
+func Check(name, value string) map[string]string {
+	details := make(map[string]string)
+	if !validName.MatchString(name) {
+		details["name"] = name + " is not a valid secret name. use letters, digits, ., _ and -"
+	}
+	switch {
+	case value == "":
+		details["value"] = "value must not be empty"
+	case len(value) > MaxValue:
+		details["value"] = fmt.Sprintf("value is %d bytes. the max is %d", len(value), MaxValue)
+	}
+
+	if len(details) == 0 {
+		return nil
+	}
+	return details
+}
+
+// Set stores value as secret name of namespace ns, replacing any value
+// it had
+func (s *Store) Set(ns, name, owner, value string) (api.Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	old, exists := s.secrets[ns][name]
+	e := entry{Name: name, Namespace: ns, Owner: owner, Created: now, Updated: now}
+	if exists {
+		e.Created = old.Created
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return api.Secret{}, err
+	}
+	e.Value = s.aead.Seal(nonce, nonce, []byte(value), additional(ns, name))
+
+	s.put(e)
+	if err := s.save(); err != nil {
+		if exists {
+			s.put(old)
+		} else {
+			delete(s.secrets[ns], name)
+		}
+		return api.Secret{}, err
+	}
+	return e.resource(), nil
+}
+
+// Value returns the value of secret name of namespace ns
+func (s *Store) Value(ns, name string) (string, error) {
+	s.mu.RLock()
+	e, ok := s.secrets[ns][name]
+	s.mu.RUnlock()
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	value, err := s.open(e)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt secret %s. error: %w", name, err)
+	}
+	return string(value), nil
+}
+
+// List returns the secrets of namespace ns in name order, without
+// their values
+func (s *Store) List(ns string) []api.Secret {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]api.Secret, 0, len(s.secrets[ns]))
+	for _, e := range s.secrets[ns] {
+		list = append(list, e.resource())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Delete removes secret name of namespace ns
+func (s *Store) Delete(ns, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.secrets[ns][name]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.secrets[ns], name)
+	if err := s.save(); err != nil {
+		s.put(e)
+		return err
+	}
+	return nil
+}
+
+// open decrypts the value of e
+func (s *Store) open(e entry) ([]byte, error) {
+	size := s.aead.NonceSize()
+	if len(e.Value) < size {
+		return nil, errors.New("value is too short")
+	}
+	return s.aead.Open(nil, e.Value[:size], e.Value[size:], additional(e.Namespace, e.Name))
+}
+
+// additional binds a sealed value to its secret so values can't be
+// swapped between secrets in the file
+func additional(ns, name string) []byte {
+	return []byte(ns + "/" + name)
+}
+
+// put adds e to the map. caller must hold the lock.
+func (s *Store) put(e entry) {
+	if s.secrets[e.Namespace] == nil {
+		s.secrets[e.Namespace] = make(map[string]entry)
+	}
+	s.secrets[e.Namespace][e.Name] = e
+}
+
+// save writes every secret to the file. it writes a temp file and
+// renames it so a crash never leaves a partial file behind.
+// caller must hold the lock.
+func (s *Store) save() error {
+	if s.file == "" {
+		return nil
+	}
+
+	list := []entry{}
+	for _, ns := range s.secrets {
+		for _, e := range ns {
+			list = append(list, e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Namespace != list[j].Namespace {
+			return list[i].Namespace < list[j].Namespace
+		}
+		return list[i].Name < list[j].Name
+	})
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.file), 0700); err != nil {
+		return err
+	}
+	// TempFile creates the file with mode 0600
+	tmp, err := ioutil.TempFile(filepath.Dir(s.file), ".secrets-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.file)
+}
+
+func (e entry) resource() api.Secret {
+	return api.Secret{
+		Name:      e.Name,
+		Namespace: e.Namespace,
+		Owner:     e.Owner,
+		Created:   e.Created,
+		Updated:   e.Updated,
+	}
+}
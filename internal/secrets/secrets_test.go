@@ -0,0 +1,121 @@
+package secrets
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	file := filepath.Join(t.TempDir(), "secrets.json")
+	s, err := Open(file, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t.Run("test set, get and list", func(t *testing.T) {
+		secret, err := s.Set("default", "gh-token", "ops", "s3cr3t-v4lue")
+		assert.NoError(t, err)
+		assert.Equal(t, "gh-token", secret.Name)
+		assert.Equal(t, "ops", secret.Owner)
+		if _, err := s.Set("default", "multi", "dev", "line-one\nline-two"); err != nil {
+			log.Fatal(err)
+		}
+
+		value, err := s.Value("default", "gh-token")
+		assert.NoError(t, err)
+		assert.Equal(t, "s3cr3t-v4lue", value)
+
+		list := s.List("default")
+		if assert.Len(t, list, 2) {
+			assert.Equal(t, "gh-token", list[0].Name)
+			assert.Equal(t, "multi", list[1].Name)
+		}
+		assert.Empty(t, s.List("infra"))
+	})
+
+	t.Run("test secrets belong to their namespace", func(t *testing.T) {
+		_, err := s.Value("infra", "gh-token")
+		assert.Equal(t, ErrNotFound, err)
+	})
+
+	t.Run("test set replaces the value and keeps the creation time", func(t *testing.T) {
+		before := s.List("default")[0]
+		after, err := s.Set("default", "gh-token", "ops", "n3w-v4lue")
+		assert.NoError(t, err)
+		assert.Equal(t, before.Created, after.Created)
+		value, _ := s.Value("default", "gh-token")
+		assert.Equal(t, "n3w-v4lue", value)
+	})
+
+	t.Run("test secrets are encrypted at rest", func(t *testing.T) {
+		data, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(data), "v4lue")
+		assert.NotContains(t, string(data), "line-one")
+		assert.Contains(t, string(data), "gh-token")
+
+		reopened, err := Open(file, key)
+		assert.NoError(t, err)
+		value, err := reopened.Value("default", "gh-token")
+		assert.NoError(t, err)
+		assert.Equal(t, "n3w-v4lue", value)
+
+		_, err = Open(file, make([]byte, 32))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "is it the right key?")
+		}
+	})
+
+	t.Run("test values can't be swapped between secrets", func(t *testing.T) {
+		data, _ := ioutil.ReadFile(file)
+		ioutil.WriteFile(file, bytes.Replace(data, []byte(`"name": "gh-token"`), []byte(`"name": "other"`), 1), 0600)
+		_, err := Open(file, key)
+		assert.Error(t, err)
+		ioutil.WriteFile(file, data, 0600)
+	})
+
+	t.Run("test delete", func(t *testing.T) {
+		assert.NoError(t, s.Delete("default", "gh-token"))
+		assert.Equal(t, ErrNotFound, s.Delete("default", "gh-token"))
+
+		reopened, err := Open(file, key)
+		assert.NoError(t, err)
+		assert.Len(t, reopened.List("default"), 1)
+	})
+}
+
+func TestCheck(t *testing.T) {
+	assert.Nil(t, Check("gh-token.v2", "x"))
+
+	details := Check("bad name", "")
+	assert.Contains(t, details, "name")
+	assert.Equal(t, "value must not be empty", details["value"])
+
+	details = Check("big", strings.Repeat("x", MaxValue+1))
+	assert.NotContains(t, details, "name")
+	assert.Contains(t, details["value"], "the max is")
+}
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "key")
+	ioutil.WriteFile(file, []byte(strings.Repeat("ab", 32)+"\n"), 0600)
+	key, err := LoadKey(file)
+	assert.NoError(t, err)
+	assert.Len(t, key, 32)
+
+	ioutil.WriteFile(file, []byte("abcd"), 0600)
+	_, err = LoadKey(file)
+	assert.EqualError(t, err, file+" must hold a 32 byte key as 64 hex digits")
+}
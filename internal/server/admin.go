@@ -0,0 +1,91 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bradyfontenot/ljw/internal/version"
+)
+
+// WithAdmin serves the admin endpoints (/metrics, /healthz, /readyz,
+// /version and /tls) on addr. the admin listener
+// never asks for client certificates. if useTLS is set it presents
+// the server certificate, otherwise it is plain http.
+func WithAdmin(addr string, useTLS bool) Option {
+	return func(s *Server) {
+		s.Admin = &http.Server{
+			Addr:         addr,
+			Handler:      s.adminRouter(),
+			ReadTimeout:  time.Duration(10 * time.Second),
+			WriteTimeout: time.Duration(10 * time.Second),
+		}
+		if useTLS {
+			s.Admin.TLSConfig = &tls.Config{
+				GetCertificate: s.certs.GetCertificate,
+				GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+					return &tls.Config{
+						MinVersion:       s.minTLS,
+						CurvePreferences: curves,
+						CipherSuites:     cipherSuites,
+						GetCertificate:   s.certs.GetCertificate,
+					}, nil
+				},
+			}
+		}
+	}
+}
+
+// adminRouter defines the admin routes
+func (s *Server) adminRouter() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metrics)
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+	mux.HandleFunc("/version", s.version)
+	mux.HandleFunc("/tls", s.tlsStatus)
+	return mux
+}
+
+// healthz reports that the process is alive and serving
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
+
+// readyz reports whether the server should be sent new work. it fails
+// while the worker is draining or can't write to its job store.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Ready  bool   `json:"ready"`
+		Reason string `json:"reason,omitempty"`
+	}
+
+	resp := response{Ready: true}
+	status := http.StatusOK
+	if err := s.worker.Ready(); err != nil {
+		resp = response{Ready: false, Reason: err.Error()}
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// version returns the build info of the server binary
+func (s *Server) version(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(version.Get())
+}
+
+// ServeAdmin runs the admin listener. it blocks like ListenAndServe.
+func (s *Server) ServeAdmin() error {
+	if s.Admin.TLSConfig != nil {
+		return s.Admin.ListenAndServeTLS("", "")
+	}
+	return s.Admin.ListenAndServe()
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+	"github.com/bradyfontenot/ljw/internal/worker"
+	"github.com/julienschmidt/httprouter"
+)
+
+// listArtifacts lists the files kept from the job matching id. it is
+// empty until the job has ended.
+func (s *Server) listArtifacts(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	artifacts, err := s.worker.JobArtifacts(jobKey(p))
+	if err != nil {
+		sendError(w, workerError(err))
+		return
+	}
+
+	resp := api.ArtifactList{ID: p.ByName("id"), Artifacts: []api.Artifact{}}
+	for _, a := range artifacts {
+		resp.Artifacts = append(resp.Artifacts, api.Artifact{Path: a.Path, Size: a.Size})
+	}
+	sendJSON(w, http.StatusOK, resp)
+}
+
+// getArtifact sends the bytes of an artifact of the job matching id.
+// ranges and conditional requests are supported.
+func (s *Server) getArtifact(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	name := strings.TrimPrefix(p.ByName("path"), "/")
+	f, _, err := s.worker.OpenArtifact(jobKey(p), name)
+	if errors.Is(err, worker.ErrArtifactNotFound) {
+		sendError(w, newError(http.StatusNotFound, api.CodeArtifactNotFound, name+" is not an artifact of job "+p.ByName("id")))
+		return
+	}
+	if err != nil {
+		sendError(w, workerError(err))
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		sendError(w, workerError(err))
+		return
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
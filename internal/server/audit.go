@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+	"github.com/bradyfontenot/ljw/internal/audit"
+	"github.com/julienschmidt/httprouter"
+)
+
+// audit log read limits
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// WithAudit records every API action in l
+func WithAudit(l *audit.Log) Option {
+	return func(s *Server) {
+		s.audit = l
+	}
+}
+
+// WithAdmins gives the client identities in ids access to admin
+// endpoints such as the audit log
+func WithAdmins(ids ...string) Option {
+	return func(s *Server) {
+		for _, id := range ids {
+			s.admins[id] = true
+		}
+	}
+}
+
+// isAdmin reports whether the client making r is an admin
+func (s *Server) isAdmin(r *http.Request) bool {
+	id := identity(r)
+	return id != "" && s.admins[id]
+}
+
+type auditKey struct{}
+
+// auditRecorder remembers the status and error code of a response
+// so they can be written to the audit log
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+	err    *api.Error
+}
+
+func (rec *auditRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (rec *auditRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// recordError is called by sendError with the error it sends
+func (rec *auditRecorder) recordError(e *api.Error) {
+	rec.err = e
+}
+
+// audited writes an audit entry for action once h has handled a request.
+// the job id and command come from the route, or from auditJob for
+// handlers that only learn them while handling the request.
+func (s *Server) audited(action string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if s.audit == nil {
+			h(w, r, p)
+			return
+		}
+
+		entry := &audit.Entry{
+			Time:       time.Now().UTC(),
+			Identity:   identity(r),
+			RemoteAddr: r.RemoteAddr,
+			RequestID:  r.Header.Get(api.RequestIDHeader),
+			Action:     action,
+		}
+		// look the job up first. it may not exist once h is done
+		if p.ByName("id") != "" {
+			entry.JobID = jobKey(p)
+			if job, err := s.worker.GetJob(entry.JobID); err == nil {
+				entry.Cmd = job.Cmd
+			}
+		}
+
+		rec := &auditRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r.WithContext(context.WithValue(r.Context(), auditKey{}, entry)), p)
+
+		entry.Status = rec.status
+		entry.Outcome = audit.OK
+		if rec.err != nil || rec.status >= 400 {
+			entry.Outcome = audit.Error
+		}
+		if rec.err != nil {
+			entry.Error = rec.err.Code
+		}
+		if err := s.audit.Write(*entry); err != nil {
+			log.Printf("could not write audit entry for %s %s. error: %v", action, entry.RequestID, err)
+		}
+	}
+}
+
+// auditJob records the job a request acted on in its audit entry.
+// empty values leave what is already recorded.
+func auditJob(ctx context.Context, id string, cmd []string) {
+	entry, ok := ctx.Value(auditKey{}).(*audit.Entry)
+	if !ok {
+		return
+	}
+	if id != "" {
+		entry.JobID = id
+	}
+	if cmd != nil {
+		entry.Cmd = cmd
+	}
+}
+
+// readAudit returns the newest audit entries matching the query.
+// only admins may read the audit log.
+func (s *Server) readAudit(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !s.isAdmin(r) {
+		sendError(w, newError(http.StatusForbidden, api.CodeForbidden, "only admins may read the audit log"))
+		return
+	}
+	if s.audit == nil {
+		sendError(w, newError(http.StatusNotFound, api.CodeNotFound, "audit log is not enabled"))
+		return
+	}
+
+	q := r.URL.Query()
+	filter := audit.Filter{
+		Identity: q.Get("identity"),
+		Action:   q.Get("action"),
+		JobID:    q.Get("job"),
+	}
+	if v := q.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			sendError(w, invalid("since", v+" is not an RFC 3339 time"))
+			return
+		}
+		filter.Since = since
+	}
+	limit := defaultAuditLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			sendError(w, invalid("limit", v+" is not a valid limit"))
+			return
+		}
+		limit = n
+		if limit > maxAuditLimit {
+			limit = maxAuditLimit
+		}
+	}
+
+	entries, err := s.audit.Read(filter, limit)
+	if err != nil {
+		sendError(w, newError(http.StatusInternalServerError, api.CodeInternal, err.Error()))
+		return
+	}
+
+	resp := api.AuditLog{Entries: []api.AuditEntry{}}
+	for _, e := range entries {
+		resp.Entries = append(resp.Entries, api.AuditEntry{
+			Time:       e.Time,
+			Identity:   e.Identity,
+			RemoteAddr: e.RemoteAddr,
+			RequestID:  e.RequestID,
+			Action:     e.Action,
+			JobID:      e.JobID,
+			Cmd:        nonNil(e.Cmd),
+			Status:     e.Status,
+			Outcome:    e.Outcome,
+			Error:      e.Error,
+			PrevHash:   e.PrevHash,
+			Hash:       e.Hash,
+		})
+	}
+	sendJSON(w, http.StatusOK, resp)
+}
+
+// nonNil returns l, or an empty slice if it is nil so it encodes as []
+func nonNil(l []string) []string {
+	if l == nil {
+		return []string{}
+	}
+	return l
+}
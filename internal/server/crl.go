@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// CRL holds the serials revoked by a certificate revocation list file.
+// the file must be signed by the CA clients are verified against.
+type CRL struct {
+	file string
+	// ca returns the CA the file must be signed by
+	ca func() *x509.Certificate
+
+	mu sync.RWMutex
+	// revoked maps serial numbers in hex to when they were revoked
+	revoked    map[string]time.Time
+	nextUpdate time.Time
+	modTime    time.Time
+}
+
+// LoadCRL reads the CRL in file and checks it was signed by the CA ca
+// returns. ca is called on every reload so a renewed CA is used.
+func LoadCRL(file string, ca func() *x509.Certificate) (*CRL, error) {
+	c := &CRL{file: file, ca: ca}
+	if _, err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// WithCRL rejects client certificates revoked by the CRL in file during
+// the handshake. the file is checked for changes every interval.
+func WithCRL(file string, interval time.Duration) Option {
+	return func(s *Server) {
+		s.crlFile = file
+		s.crlReload = interval
+	}
+}
+
+// Reload reads the CRL file again if it changed since it was last read.
+// if it can't be read or its signature is bad, the serials already
+// loaded are kept. it reports whether the file was reloaded.
+func (c *CRL) Reload() (bool, error) {
+	info, err := os.Stat(c.file)
+	if err != nil {
+		return false, err
+	}
+	c.mu.RLock()
+	unchanged := info.ModTime().Equal(c.modTime)
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(c.file)
+	if err != nil {
+		return false, err
+	}
+	// ParseCRL accepts PEM and DER
+	list, err := x509.ParseCRL(data)
+	if err != nil {
+		return false, fmt.Errorf("could not parse CRL %s. error: %w", c.file, err)
+	}
+	// the current CA, in case it was renewed too
+	ca := c.ca()
+	if err := ca.CheckCRLSignature(list); err != nil {
+		return false, fmt.Errorf("CRL %s is not signed by the CA %q. error: %w", c.file, ca.Subject.CommonName, err)
+	}
+
+	revoked := make(map[string]time.Time)
+	for _, rc := range list.TBSCertList.RevokedCertificates {
+		revoked[rc.SerialNumber.Text(16)] = rc.RevocationTime
+	}
+
+	c.mu.Lock()
+	c.revoked = revoked
+	c.nextUpdate = list.TBSCertList.NextUpdate
+	c.modTime = info.ModTime()
+	c.mu.Unlock()
+	return true, nil
+}
+
+// Run reloads the CRL every interval until ctx is done. errors are
+// logged and the last good CRL stays in force.
+func (c *CRL) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := c.Reload()
+			if err != nil {
+				log.Printf("could not reload CRL. keeping the last one loaded. error: %v", err)
+				continue
+			}
+			if reloaded {
+				c.mu.RLock()
+				log.Printf("reloaded CRL %s. %d certificates revoked", c.file, len(c.revoked))
+				c.mu.RUnlock()
+			}
+			c.warnStale()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// warnStale logs when the CRL is past its next update. it is still
+// enforced: an old list of revoked certs beats none.
+func (c *CRL) warnStale() {
+	c.mu.RLock()
+	next := c.nextUpdate
+	c.mu.RUnlock()
+	if !next.IsZero() && time.Now().After(next) {
+		log.Printf("CRL %s expired at %s. re-sign it with ljw pki crl", c.file, next.Format(time.RFC3339))
+	}
+}
+
+// check returns an error if cert has been revoked
+func (c *CRL) check(cert *x509.Certificate) error {
+	serial := cert.SerialNumber.Text(16)
+
+	c.mu.RLock()
+	at, revoked := c.revoked[serial]
+	c.mu.RUnlock()
+	if !revoked {
+		return nil
+	}
+	return fmt.Errorf("client certificate %s for %q was revoked at %s", serial, cert.Subject.CommonName, at.UTC().Format(time.RFC3339))
+}
+
+// verifyPeer is the server's VerifyPeerCertificate hook. it runs after
+// the client certificate chain has been verified against the CA. an
+// error aborts the handshake with a bad_certificate alert.
+func (s *Server) verifyPeer(_ [][]byte, chains [][]*x509.Certificate) error {
+	if s.crl == nil || len(chains) == 0 || len(chains[0]) == 0 {
+		return nil
+	}
+
+	if err := s.crl.check(chains[0][0]); err != nil {
+		log.Printf("rejected client. %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+	"github.com/bradyfontenot/ljw/internal/worker"
+	"github.com/google/uuid"
+)
+
+// maxRequestIDLen caps request ids supplied by clients
+const maxRequestIDLen = 128
+
+// newError builds an api error
+func newError(status int, code, msg string) *api.Error {
+	return &api.Error{Status: status, Code: code, Message: msg}
+}
+
+// invalid builds a 400 for a bad value in field
+func invalid(field, msg string) *api.Error {
+	e := newError(http.StatusBadRequest, api.CodeInvalidRequest, msg)
+	if field != "" {
+		e.Details = map[string]string{field: msg}
+	}
+	return e
+}
+
+// workerError maps an error returned by the worker to an api error
+func workerError(err error) *api.Error {
+	switch {
+	case errors.Is(err, worker.ErrJobNotFound):
+		return newError(http.StatusNotFound, api.CodeJobNotFound, err.Error())
+	case errors.Is(err, worker.ErrJobActive):
+		return newError(http.StatusConflict, api.CodeJobActive, err.Error())
+	case errors.Is(err, worker.ErrEmptyCommand), errors.Is(err, worker.ErrDirNotAllowed):
+		return newError(http.StatusUnprocessableEntity, api.CodeInvalidCommand, err.Error())
+	case errors.Is(err, worker.ErrDraining):
+		return newError(http.StatusServiceUnavailable, api.CodeUnavailable, err.Error())
+	case errors.Is(err, worker.ErrArtifactsDisabled):
+		return newError(http.StatusNotImplemented, api.CodeUnavailable, err.Error())
+	default:
+		return newError(http.StatusInternalServerError, api.CodeInternal, err.Error())
+	}
+}
+
+// sendError writes e in the JSON error envelope along with the
+// request id assigned by withRequestID.
+func sendError(w http.ResponseWriter, e *api.Error) {
+	e.RequestID = w.Header().Get(api.RequestIDHeader)
+	if rec, ok := w.(*auditRecorder); ok {
+		rec.recordError(e)
+	}
+
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(e.RetryAfter)))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(api.ErrorResponse{Error: e})
+}
+
+// retryAfterSeconds rounds d up to the whole seconds of a Retry-After
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// withRequestID gives every request an id, taken from the request
+// header if the client sent a usable one, and echoes it on the response.
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(api.RequestIDHeader)
+		if id == "" || len(id) > maxRequestIDLen || !printable(id) {
+			id = uuid.New().String()
+			r.Header.Set(api.RequestIDHeader, id)
+		}
+		w.Header().Set(api.RequestIDHeader, id)
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// printable reports whether s only holds printable ascii
+func printable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// notFound answers requests that match no route
+func notFound(w http.ResponseWriter, r *http.Request) {
+	sendError(w, newError(http.StatusNotFound, api.CodeNotFound, r.URL.Path+" not found"))
+}
+
+// methodNotAllowed answers requests using a method a route doesn't support
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	sendError(w, newError(http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path))
+}
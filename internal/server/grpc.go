@@ -0,0 +1,484 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+	"github.com/bradyfontenot/ljw/internal/api/ljwpb"
+	"github.com/bradyfontenot/ljw/internal/audit"
+	"github.com/bradyfontenot/ljw/internal/namespace"
+	"github.com/bradyfontenot/ljw/internal/worker"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// trailers of a failed call. errorCodeKey holds the api error code and
+// retryAfterKey the seconds to wait before retrying a rate limited call
+const (
+	errorCodeKey  = "ljw-error-code"
+	retryAfterKey = "retry-after"
+)
+
+// grpcActions names gRPC methods in the audit log after their REST
+// counterparts
+var grpcActions = map[string]string{
+	"/ljw.v1.JobService/Start":       "startJob",
+	"/ljw.v1.JobService/Stop":        "stopJob",
+	"/ljw.v1.JobService/Get":         "getJob",
+	"/ljw.v1.JobService/List":        "listJobs",
+	"/ljw.v1.JobService/StreamLogs":  "followJob",
+	"/ljw.v1.JobService/WatchStatus": "streamEvents",
+}
+
+// grpcWrites are the gRPC methods that need write access to the
+// namespace. the gRPC API only serves the default namespace.
+var grpcWrites = map[string]bool{
+	"/ljw.v1.JobService/Start": true,
+	"/ljw.v1.JobService/Stop":  true,
+}
+
+// grpcCodes maps the http status of an api error to a gRPC code
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.FailedPrecondition,
+	http.StatusUnprocessableEntity: codes.InvalidArgument,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+}
+
+// WithGRPC also serves the job API over gRPC on addr, with the same
+// mTLS config, policy and audit log as the REST API. it serves the jobs
+// of the default namespace only.
+func WithGRPC(addr string) Option {
+	return func(s *Server) {
+		s.grpcAddr = addr
+		s.GRPC = grpc.NewServer(
+			grpc.Creds(credentials.NewTLS(s.grpcTLS())),
+			grpc.UnaryInterceptor(s.grpcUnary),
+			grpc.StreamInterceptor(s.grpcStream),
+		)
+		ljwpb.RegisterJobServiceServer(s.GRPC, &jobService{s: s})
+	}
+}
+
+// grpcTLS is setupTLS with ALPN set for HTTP/2, which gRPC runs on
+func (s *Server) grpcTLS() *tls.Config {
+	cfg := s.setupTLS()
+	getConfig := cfg.GetConfigForClient
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		c, err := getConfig(hello)
+		if err != nil {
+			return nil, err
+		}
+		c.NextProtos = []string{"h2"}
+		return c, nil
+	}
+	return cfg
+}
+
+// ServeGRPC runs the gRPC listener. it blocks like ListenAndServe and
+// returns nil once the server is shut down.
+func (s *Server) ServeGRPC() error {
+	l, err := net.Listen("tcp", s.grpcAddr)
+	if err != nil {
+		return err
+	}
+	return s.GRPC.Serve(l)
+}
+
+// shutdownGRPC lets calls finish until ctx is done, then closes the
+// connections that are left
+func (s *Server) shutdownGRPC(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GRPC.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.GRPC.Stop()
+	}
+}
+
+// grpcIdentity returns the common name of the verified client certificate
+func grpcIdentity(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return ""
+	}
+	return info.State.PeerCertificates[0].Subject.CommonName
+}
+
+func remoteAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// grpcError turns e into a gRPC status, sends its api error code in a
+// trailer and records it in the call's audit entry
+func grpcError(ctx context.Context, e *api.Error) error {
+	if entry, ok := ctx.Value(auditKey{}).(*audit.Entry); ok {
+		entry.Status = e.Status
+		entry.Error = e.Code
+	}
+	trailer := metadata.Pairs(errorCodeKey, e.Code)
+	if e.RetryAfter > 0 {
+		trailer.Set(retryAfterKey, strconv.Itoa(retryAfterSeconds(e.RetryAfter)))
+	}
+	grpc.SetTrailer(ctx, trailer)
+
+	code, ok := grpcCodes[e.Status]
+	if !ok {
+		code = codes.Internal
+	}
+	return status.Error(code, e.Message)
+}
+
+func (s *Server) grpcUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var id string
+	if r, ok := req.(interface{ GetId() string }); ok {
+		id = r.GetId()
+	}
+
+	var resp interface{}
+	err := s.grpcAudited(ctx, info.FullMethod, id, func(ctx context.Context) error {
+		var err error
+		resp, err = handler(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+func (s *Server) grpcStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return s.grpcAudited(ss.Context(), info.FullMethod, "", func(ctx context.Context) error {
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	})
+}
+
+// grpcLimited turns away calls from identities over their rate limit
+func (s *Server) grpcLimited(call func(context.Context) error) func(context.Context) error {
+	return func(ctx context.Context) error {
+		if e := s.allow(worker.DefaultNamespace, grpcIdentity(ctx)); e != nil {
+			return grpcError(ctx, e)
+		}
+		return call(ctx)
+	}
+}
+
+// grpcInNamespace checks the caller's access to the default namespace
+// like inNamespace does for REST routes
+func (s *Server) grpcInNamespace(method string, call func(context.Context) error) func(context.Context) error {
+	return func(ctx context.Context) error {
+		level := namespace.Read
+		if grpcWrites[method] {
+			level = namespace.Write
+		}
+		if e := s.checkAccess(grpcIdentity(ctx), worker.DefaultNamespace, level); e != nil {
+			return grpcError(ctx, e)
+		}
+		return call(ctx)
+	}
+}
+
+// contextStream replaces the context of a server stream
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (cs *contextStream) Context() context.Context {
+	return cs.ctx
+}
+
+// grpcAudited runs call with a request id and writes an audit entry
+// for it like audited does for REST handlers. calls are rate limited
+// and checked against the default namespace like REST ones too. the
+// context passed to call is also canceled when the server shuts down.
+func (s *Server) grpcAudited(ctx context.Context, method, jobID string, call func(context.Context) error) error {
+	call = s.grpcLimited(s.grpcInNamespace(method, call))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.baseCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	requestID := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(api.RequestIDHeader); len(v) > 0 && len(v[0]) <= maxRequestIDLen && printable(v[0]) {
+			requestID = v[0]
+		}
+	}
+	if requestID == "" {
+		requestID = uuid.New().String()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(api.RequestIDHeader), requestID))
+
+	if s.audit == nil {
+		return call(ctx)
+	}
+
+	entry := &audit.Entry{
+		Time:       time.Now().UTC(),
+		Identity:   grpcIdentity(ctx),
+		RemoteAddr: remoteAddr(ctx),
+		RequestID:  requestID,
+		Action:     grpcActions[method],
+		JobID:      jobID,
+	}
+	if jobID != "" {
+		if job, err := s.worker.GetJob(jobID); err == nil {
+			entry.Cmd = job.Cmd
+		}
+	}
+
+	err := call(context.WithValue(ctx, auditKey{}, entry))
+
+	entry.Outcome = audit.OK
+	switch {
+	case err == nil:
+		entry.Status = http.StatusOK
+	case entry.Error == "":
+		// failed outside the handlers, for example sending to the client
+		entry.Status = http.StatusInternalServerError
+		entry.Error = api.CodeInternal
+		entry.Outcome = audit.Error
+	default:
+		entry.Outcome = audit.Error
+	}
+	if err := s.audit.Write(*entry); err != nil {
+		log.Printf("could not write audit entry for %s %s. error: %v", entry.Action, requestID, err)
+	}
+	return err
+}
+
+// jobService implements the gRPC JobService with the server's worker
+type jobService struct {
+	ljwpb.UnimplementedJobServiceServer
+	s *Server
+}
+
+func (js *jobService) Start(ctx context.Context, req *ljwpb.StartRequest) (*ljwpb.Job, error) {
+	spec, e := js.s.checkStart(ctx, grpcIdentity(ctx), remoteAddr(ctx), worker.DefaultNamespace, api.StartJobRequest{
+		Cmd:      req.Cmd,
+		Labels:   req.Labels,
+		Webhooks: req.Webhooks,
+	})
+	if e != nil {
+		return nil, grpcError(ctx, e)
+	}
+
+	job, e := js.s.submit(ctx, spec)
+	if e != nil {
+		return nil, grpcError(ctx, e)
+	}
+	return jobMessage(job), nil
+}
+
+func (js *jobService) Stop(ctx context.Context, req *ljwpb.StopRequest) (*ljwpb.StopResponse, error) {
+	key, e := defaultKey(req.Id)
+	if e != nil {
+		return nil, grpcError(ctx, e)
+	}
+	stopped, err := js.s.worker.StopJob(key)
+	if err != nil {
+		return nil, grpcError(ctx, workerError(err))
+	}
+	return &ljwpb.StopResponse{Id: req.Id, Stopped: stopped}, nil
+}
+
+func (js *jobService) Get(ctx context.Context, req *ljwpb.GetRequest) (*ljwpb.Job, error) {
+	key, e := defaultKey(req.Id)
+	if e != nil {
+		return nil, grpcError(ctx, e)
+	}
+	job, err := js.s.worker.GetJob(key)
+	if err != nil {
+		return nil, grpcError(ctx, workerError(err))
+	}
+	return jobMessage(job), nil
+}
+
+func (js *jobService) List(ctx context.Context, _ *ljwpb.ListRequest) (*ljwpb.ListResponse, error) {
+	resp := &ljwpb.ListResponse{}
+	for _, job := range js.s.worker.Jobs() {
+		if job.Namespace == worker.DefaultNamespace {
+			resp.Jobs = append(resp.Jobs, jobMessage(job))
+		}
+	}
+	return resp, nil
+}
+
+func (js *jobService) StreamLogs(req *ljwpb.StreamLogsRequest, stream ljwpb.JobService_StreamLogsServer) error {
+	ctx := stream.Context()
+	key, e := defaultKey(req.Id)
+	if e != nil {
+		return grpcError(ctx, e)
+	}
+	job, err := js.s.worker.GetJob(key)
+	if err != nil {
+		return grpcError(ctx, workerError(err))
+	}
+	auditJob(ctx, job.ID, job.Cmd)
+	if req.Offset < 0 {
+		return grpcError(ctx, invalid("offset", "offset must not be negative"))
+	}
+	offset := int(req.Offset)
+
+	if !req.Follow {
+		lines, err := js.s.worker.JobOutput(job.ID)
+		if err != nil {
+			return grpcError(ctx, workerError(err))
+		}
+		if offset > len(lines) {
+			offset = len(lines)
+		}
+		return stream.Send(logChunk(job, worker.Chunk{Lines: lines[offset:], Next: len(lines)}))
+	}
+
+	for {
+		job, chunk, err := js.s.worker.FollowJob(ctx, job.ID, offset)
+		if err != nil {
+			return grpcError(ctx, workerError(err))
+		}
+		// the client went away or the server is shutting down
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err := stream.Send(logChunk(job, chunk)); err != nil {
+			return err
+		}
+		if job.ExitCode != nil {
+			return nil
+		}
+		offset = chunk.Next
+	}
+}
+
+func (js *jobService) WatchStatus(req *ljwpb.WatchStatusRequest, stream ljwpb.JobService_WatchStatusServer) error {
+	ctx := stream.Context()
+	filter := worker.EventFilter{
+		JobID:      req.JobId,
+		Namespaces: []string{worker.DefaultNamespace},
+		Owner:      req.Owner,
+		Labels:     req.Labels,
+	}
+	cursor := req.Cursor
+	if cursor == 0 {
+		cursor = js.s.worker.LatestEvent()
+	}
+
+	for ctx.Err() == nil {
+		var events []worker.Event
+		events, cursor = js.s.worker.Events(ctx, cursor, filter)
+
+		for _, e := range events {
+			// output goes through StreamLogs
+			if e.Type == worker.EventOutput || e.Type == worker.EventGap && !lostLifecycle(e.Dropped) {
+				continue
+			}
+			if err := stream.Send(statusEvent(e)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// defaultKey returns the key of job id in the default namespace. ids
+// naming a job in another namespace as ns/id are not found.
+func defaultKey(id string) (string, *api.Error) {
+	if strings.Contains(id, "/") {
+		return "", newError(http.StatusNotFound, api.CodeJobNotFound, id+" is not a valid id")
+	}
+	return id, nil
+}
+
+// jobMessage converts job for the gRPC API. see jobResource
+func jobMessage(job worker.Info) *ljwpb.Job {
+	msg := &ljwpb.Job{
+		Id:       job.ID,
+		Cmd:      job.Cmd,
+		Owner:    job.Owner,
+		Labels:   job.Labels,
+		Status:   job.Status,
+		ExitCode: exitCode32(job.ExitCode),
+		Created:  timestamppb.New(job.Created),
+	}
+	if !job.Started.IsZero() {
+		msg.Started = timestamppb.New(job.Started)
+	}
+	if !job.Ended.IsZero() {
+		msg.Ended = timestamppb.New(job.Ended)
+	}
+	return msg
+}
+
+func logChunk(job worker.Info, chunk worker.Chunk) *ljwpb.LogChunk {
+	return &ljwpb.LogChunk{
+		Lines:    chunk.Lines,
+		Next:     int64(chunk.Next),
+		Status:   job.Status,
+		ExitCode: exitCode32(job.ExitCode),
+	}
+}
+
+// lostLifecycle reports whether the kinds dropped by a gap include
+// lifecycle events
+func lostLifecycle(dropped []string) bool {
+	for _, kind := range dropped {
+		if kind == worker.KindLifecycle {
+			return true
+		}
+	}
+	return false
+}
+
+func statusEvent(e worker.Event) *ljwpb.StatusEvent {
+	return &ljwpb.StatusEvent{
+		Id:       e.ID,
+		Type:     e.Type,
+		Time:     timestamppb.New(e.Time),
+		JobId:    e.JobID,
+		Owner:    e.Owner,
+		Labels:   e.Labels,
+		Status:   e.Status,
+		ExitCode: exitCode32(e.ExitCode),
+	}
+}
+
+func exitCode32(code *int) *int32 {
+	if code == nil {
+		return nil
+	}
+	c := int32(*code)
+	return &c
+}
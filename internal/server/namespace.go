@@ -0,0 +1,174 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+	"github.com/bradyfontenot/ljw/internal/namespace"
+	"github.com/bradyfontenot/ljw/internal/quota"
+	"github.com/bradyfontenot/ljw/internal/worker"
+	"github.com/julienschmidt/httprouter"
+)
+
+// WithNamespaces adds the namespaces in n. jobs are started in the
+// default namespace unless the request is under /namespaces/:ns.
+func WithNamespaces(n *namespace.Namespaces) Option {
+	return func(s *Server) {
+		s.namespaces = n
+	}
+}
+
+// access returns the access identity has to namespace ns. admins may
+// write to every namespace.
+func (s *Server) access(identity, ns string) string {
+	if identity != "" && s.admins[identity] && s.namespaces.Exists(ns) {
+		return namespace.Write
+	}
+	return s.namespaces.Access(identity, ns)
+}
+
+// checkAccess returns an error unless identity has at least level
+// access to namespace ns
+func (s *Server) checkAccess(identity, ns, level string) *api.Error {
+	if !s.namespaces.Exists(ns) {
+		return newError(http.StatusNotFound, api.CodeNamespaceNotFound, fmt.Sprintf("namespace %s not found", ns))
+	}
+	if !namespace.Allows(s.access(identity, ns), level) {
+		return newError(http.StatusForbidden, api.CodeForbidden, fmt.Sprintf("%s has no %s access to namespace %s", clientName(identity), level, ns))
+	}
+	return nil
+}
+
+// crossNamespace are the actions that don't act in a single namespace
+// when their route is outside /namespaces. listing jobs and streaming
+// events span the namespaces the caller can read, pruning those it can
+// write to. the others aren't about the jobs of a namespace.
+var crossNamespace = map[string]bool{
+	"listJobs":       true,
+	"streamEvents":   true,
+	"pruneJobs":      true,
+	"readAudit":      true,
+	"listNamespaces": true,
+	"openAPI":        true,
+}
+
+// inNamespace lets a request through if the caller may read the
+// namespace of the route, or write to it for methods other than GET
+func (s *Server) inNamespace(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		level := namespace.Write
+		if r.Method == http.MethodGet {
+			level = namespace.Read
+		}
+		if e := s.checkAccess(identity(r), routeNamespace(p), level); e != nil {
+			sendError(w, e)
+			return
+		}
+		h(w, r, p)
+	}
+}
+
+// routeNamespace returns the namespace a route acts in. routes outside
+// /namespaces act in the default one.
+func routeNamespace(p httprouter.Params) string {
+	if ns := p.ByName("ns"); ns != "" {
+		return ns
+	}
+	return worker.DefaultNamespace
+}
+
+// jobKey returns the worker key of the job a route acts on
+func jobKey(p httprouter.Params) string {
+	return worker.Key(p.ByName("ns"), p.ByName("id"))
+}
+
+// readable returns the namespaces whose jobs a listing shows: the
+// namespace of the route, or outside /namespaces every namespace the
+// caller can read. it is nil for admins, who see every job.
+func (s *Server) readable(r *http.Request, p httprouter.Params) []string {
+	if ns := p.ByName("ns"); ns != "" {
+		return []string{ns}
+	}
+	if s.isAdmin(r) {
+		return nil
+	}
+
+	list := []string{}
+	for _, ns := range s.namespaces.Names() {
+		if namespace.Allows(s.access(identity(r), ns), namespace.Read) {
+			list = append(list, ns)
+		}
+	}
+	return list
+}
+
+// writable returns the namespaces a prune removes jobs from: every
+// namespace the caller can write to. it is nil for admins, who may
+// prune every job.
+func (s *Server) writable(r *http.Request) []string {
+	if s.isAdmin(r) {
+		return nil
+	}
+
+	list := []string{}
+	for _, ns := range s.namespaces.Names() {
+		if namespace.Allows(s.access(identity(r), ns), namespace.Write) {
+			list = append(list, ns)
+		}
+	}
+	return list
+}
+
+// visible reports whether a job in ns is shown by a listing of the
+// namespaces returned by readable
+func visible(namespaces []string, ns string) bool {
+	if namespaces == nil {
+		return true
+	}
+	for _, n := range namespaces {
+		if n == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// quotasFor returns the quotas of namespace ns, the server's own if
+// it has none
+func (s *Server) quotasFor(ns string) *quota.Quotas {
+	if q := s.namespaces.Quotas(ns); q != nil {
+		return q
+	}
+	return s.quotas
+}
+
+// listNamespaces lists the namespaces the caller can access
+func (s *Server) listNamespaces(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	resp := api.NamespaceList{Namespaces: []api.Namespace{}}
+	for _, ns := range s.namespaces.Names() {
+		access := s.access(identity(r), ns)
+		if access == namespace.None {
+			continue
+		}
+		l := s.namespaces.Config(ns).Limits
+		resp.Namespaces = append(resp.Namespaces, api.Namespace{
+			Name:   ns,
+			Access: access,
+			Limits: api.JobLimits{
+				Timeout:     l.Timeout,
+				CPUSeconds:  l.CPUSeconds,
+				MemoryBytes: l.MemoryBytes,
+			},
+		})
+	}
+	sendJSON(w, http.StatusOK, resp)
+}
+
+// clientName names identity in error messages
+func clientName(identity string) string {
+	if identity == "" {
+		return "anonymous client"
+	}
+	return identity
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+// peerUID needs SO_PEERCRED, which only linux has
+func peerUID(c net.Conn) (uint32, error) {
+	return 0, errors.New("peer credentials are only supported on linux")
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+	"github.com/bradyfontenot/ljw/internal/quota"
+	"github.com/bradyfontenot/ljw/internal/worker"
+	"github.com/julienschmidt/httprouter"
+)
+
+// WithQuotas rate limits API calls and caps the jobs and cpu time of
+// each client identity
+func WithQuotas(q *quota.Quotas) Option {
+	return func(s *Server) {
+		s.quotas = q
+	}
+}
+
+// rateLimited turns away calls from identities over their rate limit.
+// calls under /namespaces/:ns use the quotas of the namespace.
+func (s *Server) rateLimited(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if e := s.allow(routeNamespace(p), identity(r)); e != nil {
+			sendError(w, e)
+			return
+		}
+		h(w, r, p)
+	}
+}
+
+// allow takes a call from identity's rate limit in namespace ns
+func (s *Server) allow(ns, identity string) *api.Error {
+	q := s.quotasFor(ns)
+	if q == nil {
+		return nil
+	}
+	if err := q.Allow(identity); err != nil {
+		return quotaError(err)
+	}
+	return nil
+}
+
+// submit starts the job in spec if its owner is within the quotas of
+// its namespace. the check and the start share a lock so concurrent
+// requests can't both take the last slot. it is shared by the REST and
+// gRPC APIs.
+func (s *Server) submit(ctx context.Context, spec worker.Spec) (worker.Info, *api.Error) {
+	if q := s.quotasFor(spec.Namespace); q != nil {
+		s.startMu.Lock()
+		defer s.startMu.Unlock()
+
+		if err := q.CheckStart(spec.Owner, s.usage(spec.Namespace, spec.Owner)); err != nil {
+			return worker.Info{}, quotaError(err)
+		}
+		spec.MaxRunning = q.Limits(spec.Owner).MaxRunning
+	}
+
+	job, err := s.worker.StartJob(spec)
+	if err != nil {
+		return worker.Info{}, workerError(err)
+	}
+	auditJob(ctx, job.Key(), nil)
+	return job, nil
+}
+
+// usage returns what identity's jobs in namespace ns are using of the worker
+func (s *Server) usage(ns, identity string) quota.Usage {
+	u := s.worker.Usage(ns, identity)
+	return quota.Usage{
+		Running:    u.Running,
+		Queued:     u.Queued,
+		CPUSeconds: u.CPU.Seconds(),
+		CPUReset:   u.CPUReset,
+	}
+}
+
+// quotaError maps an error from the quotas to a 429
+func quotaError(err error) *api.Error {
+	var qe *quota.ExceededError
+	if !errors.As(err, &qe) {
+		return newError(http.StatusInternalServerError, api.CodeInternal, err.Error())
+	}
+
+	code := api.CodeQuotaExceeded
+	if qe.Quota == quota.Rate {
+		code = api.CodeRateLimited
+	}
+	e := newError(http.StatusTooManyRequests, code, err.Error())
+	e.Details = map[string]string{"quota": qe.Quota}
+	e.RetryAfter = qe.RetryAfter
+	return e
+}
+
+// getQuota returns the caller's limits and usage in the namespace of
+// the route. admins can ask for another identity with ?identity=
+func (s *Server) getQuota(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := identity(r)
+	if v := r.URL.Query().Get("identity"); v != "" && v != id {
+		if !s.isAdmin(r) {
+			sendError(w, newError(http.StatusForbidden, api.CodeForbidden, "only admins may read the quota of another identity"))
+			return
+		}
+		id = v
+	}
+
+	ns := routeNamespace(p)
+	u := s.usage(ns, id)
+	resp := api.Quota{
+		Identity:  id,
+		Namespace: ns,
+		Usage: api.QuotaUsage{
+			RateRemaining: -1,
+			Running:       u.Running,
+			Queued:        u.Queued,
+			CPUSeconds:    u.CPUSeconds,
+			CPUReset:      u.CPUReset.UTC(),
+		},
+	}
+	if q := s.quotasFor(ns); q != nil {
+		l := q.Limits(id)
+		resp.Limits = api.QuotaLimits{
+			Rate:             l.Rate,
+			Burst:            l.Burst,
+			MaxRunning:       l.MaxRunning,
+			MaxQueued:        l.MaxQueued,
+			CPUSecondsPerDay: l.CPUSecondsPerDay,
+		}
+		resp.Usage.RateRemaining = q.Remaining(id)
+	}
+	sendJSON(w, http.StatusOK, resp)
+}
@@ -1,42 +1,117 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/bradyfontenot/ljw/internal/api"
+	"github.com/bradyfontenot/ljw/internal/worker"
 	"github.com/julienschmidt/httprouter"
 )
 
-// Response is a catch all response struct
+// Response is a catch all response struct used by the deprecated
+// unversioned routes. /api/v1 uses the resource types in package api.
 type Response struct {
-	Success bool     `json:"success,omitempty"`
-	ID      string   `json:"id,omitempty"`
-	Status  string   `json:"status,omitempty"`
-	Cmd     string   `json:"cmd,omitempty"`
-	Output  string   `json:"output,omitempty"`
-	IDList  []string `json:"idList,omitempty"`
+	Success  bool     `json:"success,omitempty"`
+	ID       string   `json:"id,omitempty"`
+	Status   string   `json:"status,omitempty"`
+	Cmd      string   `json:"cmd,omitempty"`
+	Owner    string   `json:"owner,omitempty"`
+	Output   string   `json:"output,omitempty"`
+	IDList   []string `json:"idList,omitempty"`
+	Offset   int      `json:"offset,omitempty"`
+	ExitCode *int     `json:"exitCode,omitempty"`
+
+	Deliveries []worker.Delivery `json:"deliveries,omitempty"`
 }
 
+// long-poll timeouts. the max stays under the server's WriteTimeout
+// so a poll always gets to write its response.
+const (
+	defaultPollTimeout = 20 * time.Second
+	maxPollTimeout     = 25 * time.Second
+)
+
 // router creates handler and defines the routes.
 func (s *Server) router() *httprouter.Router {
 
 	r := httprouter.New()
+	r.NotFound = http.HandlerFunc(notFound)
+	r.MethodNotAllowed = http.HandlerFunc(methodNotAllowed)
 
-	r.GET("/api/jobs", s.listJobs)
-	r.POST("/api/jobs", s.startJob)
-	r.GET("/api/jobs/:id", s.getJob)
-	r.DELETE("/api/jobs/:id", s.stopJob)
-	r.GET("/api/jobs/:id/log", s.getJob)
+	// every route is instrumented for the metrics endpoint, recorded
+	// in the audit log as action and rate limited per identity. routes
+	// acting in a namespace check the caller's access to it
+	handle := func(method, path, action string, h httprouter.Handle) {
+		if strings.Contains(path, "/:ns") || !crossNamespace[action] {
+			h = s.inNamespace(h)
+		}
+		r.Handle(method, path, s.instrument(method, path, s.audited(action, s.rateLimited(h))))
+	}
+
+	// v1 routes come from the same table as the OpenAPI document
+	v1 := s.v1Handlers()
+	for _, rt := range api.Routes {
+		h, ok := v1[rt.Operation]
+		if !ok {
+			panic("no handler for " + rt.Operation)
+		}
+		handle(rt.Method, rt.Path, rt.Operation, h)
+	}
+	handle(http.MethodGet, api.V1+"/openapi.json", "openAPI", s.openAPI)
+
+	// deprecated unversioned routes, kept for older clients
+	legacy := func(method, path, action string, h httprouter.Handle) {
+		handle(method, path, action, deprecated(h))
+	}
+	legacy(http.MethodGet, "/api/jobs", "listJobs", s.listJobs)
+	legacy(http.MethodPost, "/api/jobs", "startJob", s.startJob)
+	legacy(http.MethodGet, "/api/jobs/:id", "getJob", s.getJob)
+	legacy(http.MethodDelete, "/api/jobs/:id", "stopJob", s.stopJob)
+	legacy(http.MethodGet, "/api/jobs/:id/log", "getJobLog", s.getJob)
+	legacy(http.MethodGet, "/api/jobs/:id/output", "followJob", s.followJob)
+	legacy(http.MethodGet, "/api/jobs/:id/wait", "waitJob", s.waitJob)
+	legacy(http.MethodGet, "/api/jobs/:id/webhooks", "jobWebhooks", s.jobWebhooks)
+	legacy(http.MethodGet, "/api/jobs/:id/artifacts", "listArtifacts", s.listArtifacts)
+	legacy(http.MethodGet, "/api/jobs/:id/artifacts/*path", "getArtifact", s.getArtifact)
+	legacy(http.MethodPost, "/api/jobs/:id/purge", "purgeJob", s.purgeJob)
+	legacy(http.MethodPost, "/api/prune", "pruneJobs", s.pruneJobs)
+	legacy(http.MethodGet, "/api/events", "streamEvents", s.streamEvents)
+	legacy(http.MethodGet, "/api/audit", "readAudit", s.readAudit)
+	legacy(http.MethodGet, "/api/quota", "getQuota", s.getQuota)
 
 	return r
 }
 
-// listJobs retrieves list of ids for jobs currently in process
-func (s *Server) listJobs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+// deprecated marks responses from an unversioned route and links
+// to its replacement under /api/v1
+func deprecated(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		successor := api.V1 + strings.TrimPrefix(r.URL.Path, "/api")
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+		h(w, r, p)
+	}
+}
+
+// listJobs retrieves list of ids for jobs currently in process.
+// jobs outside the default namespace are listed as ns/id.
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 
-	// get list of jobs
-	idList := s.worker.ListJobs()
+	// get list of jobs the caller can see
+	namespaces := s.readable(r, p)
+	idList := []string{}
+	for _, key := range s.worker.ListJobs() {
+		if ns, _ := worker.SplitKey(key); visible(namespaces, ns) {
+			idList = append(idList, key)
+		}
+	}
 
 	// set header properties
 	w.Header().Set("Content-Type", "application/json")
@@ -50,19 +125,24 @@ func (s *Server) listJobs(w http.ResponseWriter, r *http.Request, _ httprouter.P
 }
 
 // startJob starts a new job and returns new job id if successful
-func (s *Server) startJob(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	type request struct {
-		Cmd []string
+func (s *Server) startJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	spec, e := s.startSpec(w, r, routeNamespace(p))
+	if e != nil {
+		sendError(w, e)
+		return
+	}
+	// pass cmd to worker to build new job and receive job info
+	job, e := s.submit(r.Context(), spec)
+	if e != nil {
+		sendError(w, e)
+		return
 	}
-	// decode request msg
-	var req request
-	err := json.NewDecoder(r.Body).Decode(&req)
+
+	output, err := s.worker.JobOutput(job.Key())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		sendError(w, workerError(err))
 		return
 	}
-	// pass cmd to worker to build new job and receive job props
-	job := s.worker.StartJob(req.Cmd)
 
 	// set header properties
 	w.Header().Set("Content-Type", "application/json")
@@ -70,10 +150,12 @@ func (s *Server) startJob(w http.ResponseWriter, r *http.Request, _ httprouter.P
 
 	// build response msg & send
 	resp := Response{
-		ID:     job["id"],
-		Cmd:    job["cmd"],
-		Status: job["status"],
-		Output: job["output"],
+		ID:       job.ID,
+		Cmd:      strings.Join(job.Cmd, " "),
+		Owner:    job.Owner,
+		Status:   job.Status,
+		Output:   strings.Join(output, " "),
+		ExitCode: job.ExitCode,
 	}
 	sendResp(w, resp)
 }
@@ -81,9 +163,9 @@ func (s *Server) startJob(w http.ResponseWriter, r *http.Request, _ httprouter.P
 // stopJob stops job if it is currently running.
 // returns a boolean to confirm if job was canceled or not
 func (s *Server) stopJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	result, err := s.worker.StopJob(p.ByName("id"))
+	result, err := s.worker.StopJob(jobKey(p))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		sendError(w, workerError(err))
 		return
 	}
 
@@ -101,9 +183,55 @@ func (s *Server) stopJob(w http.ResponseWriter, r *http.Request, p httprouter.Pa
 // getJob returns job matching id
 // called by client funcs: JobLog() & JobStatus()
 func (s *Server) getJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	job, err := s.worker.GetJob(p.ByName("id"))
+	job, err := s.worker.GetJob(jobKey(p))
+	if err != nil {
+		sendError(w, workerError(err))
+		return
+	}
+	output, err := s.worker.JobOutput(job.Key())
+	if err != nil {
+		sendError(w, workerError(err))
+		return
+	}
+
+	// set header properties
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// build response msg & send
+	resp := Response{
+		ID:       job.ID,
+		Cmd:      strings.Join(job.Cmd, " "),
+		Owner:    job.Owner,
+		Status:   job.Status,
+		Output:   strings.Join(output, " "),
+		ExitCode: job.ExitCode,
+	}
+	sendResp(w, resp)
+}
+
+// followJob long-polls for output written by a job since ?offset=.
+// returns as soon as there is new output or the job has ended, or
+// with empty output once ?timeout= (default 20s, max 25s) expires.
+func (s *Server) followJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	offset, e := offsetParam(r)
+	if e != nil {
+		sendError(w, e)
+		return
+	}
+
+	timeout, err := pollTimeout(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		sendError(w, invalid("timeout", err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	job, chunk, err := s.worker.FollowJob(ctx, jobKey(p), offset)
+	if err != nil {
+		sendError(w, workerError(err))
 		return
 	}
 
@@ -113,20 +241,360 @@ func (s *Server) getJob(w http.ResponseWriter, r *http.Request, p httprouter.Par
 
 	// build response msg & send
 	resp := Response{
-		ID:     job["id"],
-		Cmd:    job["cmd"],
-		Status: job["status"],
-		Output: job["output"],
+		ID:       job.ID,
+		Status:   job.Status,
+		Output:   strings.Join(chunk.Lines, ""),
+		Offset:   chunk.Next,
+		ExitCode: job.ExitCode,
 	}
 	sendResp(w, resp)
 }
 
+// waitJob long-polls until the job ends or ?timeout= (default 20s,
+// max 25s) expires. either way it returns the job's current status;
+// exitCode is only set if the job has ended.
+func (s *Server) waitJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	timeout, err := pollTimeout(r)
+	if err != nil {
+		sendError(w, invalid("timeout", err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	job, err := s.worker.WaitJob(ctx, jobKey(p))
+	if err != nil {
+		sendError(w, workerError(err))
+		return
+	}
+
+	// set header properties
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// build response msg & send
+	resp := Response{
+		ID:       job.ID,
+		Cmd:      strings.Join(job.Cmd, " "),
+		Status:   job.Status,
+		ExitCode: job.ExitCode,
+	}
+	sendResp(w, resp)
+}
+
+// purgeJob deletes the record of a job that has ended.
+// jobs still queued or running must be stopped first.
+func (s *Server) purgeJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	err := s.worker.RemoveJob(jobKey(p))
+	if err != nil {
+		sendError(w, workerError(err))
+		return
+	}
+
+	// set header properties
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// build response msg & send
+	resp := Response{
+		Success: true,
+	}
+	sendResp(w, resp)
+}
+
+// pruneJobs removes ended jobs outside a retention policy from the
+// namespaces the caller can write to and returns their ids. uses the
+// server's policy unless one is posted.
+func (s *Server) pruneJobs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	policy, e := s.prunePolicy(w, r)
+	if e != nil {
+		sendError(w, e)
+		return
+	}
+
+	removed := s.worker.Prune(policy, s.writable(r))
+
+	// set header properties
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// build response msg & send
+	resp := Response{
+		IDList: removed,
+	}
+	sendResp(w, resp)
+}
+
+// jobWebhooks returns the webhook delivery log for a job
+func (s *Server) jobWebhooks(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	deliveries, err := s.worker.JobWebhooks(jobKey(p))
+	if err != nil {
+		sendError(w, workerError(err))
+		return
+	}
+
+	// set header properties
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// build response msg & send
+	resp := Response{
+		ID:         p.ByName("id"),
+		Deliveries: deliveries,
+	}
+	sendResp(w, resp)
+}
+
+// streamEvents sends job lifecycle events as server-sent events.
+// filters: ?job=<id>, ?owner=<identity>, ?label=<key=value> (repeatable).
+// only events of jobs in namespaces the caller can read are sent.
+// the stream resumes after the event id in the Last-Event-ID header or
+// ?cursor=, otherwise it starts with the next event. the server ends
+// the stream after ?timeout= (default 20s, max 25s) and clients
+// reconnect with the last id they saw.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	s.sendEvents(w, r, p, func(e worker.Event) interface{} { return e })
+}
+
+// sendEvents streams the events selected by the route and query of r,
+// encoding each one as the value returned by encode.
+func (s *Server) sendEvents(w http.ResponseWriter, r *http.Request, p httprouter.Params, encode func(worker.Event) interface{}) {
+	q := r.URL.Query()
+
+	filter := worker.EventFilter{
+		Namespaces: s.readable(r, p),
+		Owner:      q.Get("owner"),
+	}
+	if filter.Namespaces != nil && len(filter.Namespaces) == 0 {
+		sendError(w, newError(http.StatusForbidden, api.CodeForbidden, clientName(identity(r))+" can't read any namespace"))
+		return
+	}
+	if id := q.Get("job"); id != "" {
+		filter.JobID = worker.Key(p.ByName("ns"), id)
+	}
+	for _, l := range q["label"] {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			sendError(w, invalid("label", l+" is not a valid label filter. use key=value"))
+			return
+		}
+		if filter.Labels == nil {
+			filter.Labels = make(map[string]string)
+		}
+		filter.Labels[kv[0]] = kv[1]
+	}
+
+	cursor := s.worker.LatestEvent()
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = q.Get("cursor")
+	}
+	if v != "" {
+		c, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			sendError(w, invalid("cursor", v+" is not a valid cursor"))
+			return
+		}
+		cursor = c
+	}
+
+	timeout, err := pollTimeout(r)
+	if err != nil {
+		sendError(w, invalid("timeout", err.Error()))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendError(w, newError(http.StatusInternalServerError, api.CodeInternal, "streaming not supported"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	// set header properties
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for ctx.Err() == nil {
+		var events []worker.Event
+		events, cursor = s.worker.Events(ctx, cursor, filter)
+
+		for _, e := range events {
+			data, err := json.Marshal(encode(e))
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// startSpec decodes and validates a start request into the spec of a
+// job in namespace ns owned by the caller, and checks the command
+// against the policy
+func (s *Server) startSpec(w http.ResponseWriter, r *http.Request, ns string) (worker.Spec, *api.Error) {
+	var req api.StartJobRequest
+	if e := decodeStrict(w, r, maxStartBody, &req); e != nil {
+		return worker.Spec{}, e
+	}
+	return s.checkStart(r.Context(), identity(r), r.RemoteAddr, ns, req)
+}
+
+// checkStart validates a start request from identity and checks it
+// against the policy. the job gets the resource limits of namespace
+// ns. it is shared by the REST and gRPC APIs.
+func (s *Server) checkStart(ctx context.Context, identity, remoteAddr, ns string, req api.StartJobRequest) (worker.Spec, *api.Error) {
+	// rejected commands are audited too
+	auditJob(ctx, "", req.Cmd)
+	if e := validateStart(req); e != nil {
+		return worker.Spec{}, e
+	}
+	// jobs may only run, and keep artifacts, inside the job roots
+	if req.Dir != "" {
+		if msg := s.worker.CheckDir(req.Dir); msg != "" {
+			e := newError(http.StatusUnprocessableEntity, api.CodeInvalidCommand, "invalid start request")
+			e.Details = map[string]string{"dir": msg}
+			return worker.Spec{}, e
+		}
+	}
+	if s.policy != nil {
+		if err := s.policy.Check(identity, req.Cmd, req.Dir); err != nil {
+			log.Printf("policy denied job from %s. %v", remoteAddr, err)
+			return worker.Spec{}, newError(http.StatusForbidden, api.CodeForbidden, err.Error())
+		}
+	}
+	secrets, e := s.resolveSecrets(ns, req.Secrets)
+	if e != nil {
+		return worker.Spec{}, e
+	}
+
+	return worker.Spec{
+		Namespace: ns,
+		Cmd:       req.Cmd,
+		Owner:     identity,
+		Labels:    req.Labels,
+		Webhooks:  req.Webhooks,
+		Secrets:   secrets,
+		Dir:       req.Dir,
+		Artifacts: req.Artifacts,
+		Limits:    s.namespaces.Limits(ns),
+	}, nil
+}
+
+// offsetParam reads the ?offset= of an output poll
+func offsetParam(r *http.Request) (int, *api.Error) {
+	v := r.URL.Query().Get("offset")
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, invalid("offset", v+" is not a valid offset")
+	}
+	return n, nil
+}
+
+// maxPruneBody is the largest body of a prune request
+const maxPruneBody = 4 << 10
+
+// prunePolicy decodes the retention policy posted to a prune request.
+// an empty body means use the server policy.
+func (s *Server) prunePolicy(w http.ResponseWriter, r *http.Request) (worker.RetentionPolicy, *api.Error) {
+	if r.ContentLength == 0 {
+		return s.worker.RetentionPolicy(), nil
+	}
+	var req api.PruneRequest
+	if e := decodeStrict(w, r, maxPruneBody, &req); e != nil {
+		return worker.RetentionPolicy{}, e
+	}
+
+	details := make(map[string]string)
+	policy := worker.RetentionPolicy{
+		MaxCount:    req.MaxCount,
+		MaxLogBytes: req.MaxLogBytes,
+	}
+	if req.MaxAge != "" {
+		d, err := time.ParseDuration(req.MaxAge)
+		if err != nil || d < 0 {
+			details["maxAge"] = req.MaxAge + " is not a valid max age"
+		}
+		policy.MaxAge = d
+	}
+	if req.MaxCount < 0 {
+		details["maxCount"] = "must not be negative"
+	}
+	if req.MaxLogBytes < 0 {
+		details["maxLogBytes"] = "must not be negative"
+	}
+	if len(details) > 0 {
+		e := newError(http.StatusBadRequest, api.CodeInvalidRequest, "invalid prune request")
+		e.Details = details
+		return worker.RetentionPolicy{}, e
+	}
+	return policy, nil
+}
+
+// pollTimeout reads the ?timeout= duration of a long-poll request
+func pollTimeout(r *http.Request) (time.Duration, error) {
+	v := r.URL.Query().Get("timeout")
+	if v == "" {
+		return defaultPollTimeout, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s is not a valid timeout", v)
+	}
+	if d > maxPollTimeout {
+		d = maxPollTimeout
+	}
+	return d, nil
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrument records the status code and latency of each request to route
+func (s *Server) instrument(method, route string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		start := time.Now()
+		rec := &statusRecorder{w, http.StatusOK}
+
+		h(rec, r, p)
+
+		s.httpRequests.Inc(method, route, strconv.Itoa(rec.status))
+		s.httpDuration.Observe(time.Since(start).Seconds(), method, route)
+	}
+}
+
 // helper function for marshalling json & sending response
 func sendResp(w http.ResponseWriter, msg Response) {
 	resp, err := json.Marshal(msg)
 	if err != nil {
 		e := fmt.Errorf("could not marshall json. error: %w", err)
-		http.Error(w, e.Error(), http.StatusInternalServerError)
+		sendError(w, newError(http.StatusInternalServerError, api.CodeInternal, e.Error()))
 		return
 	}
 	w.Write(resp)
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+	"github.com/bradyfontenot/ljw/internal/secrets"
+	"github.com/bradyfontenot/ljw/internal/worker"
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxSecretBody is the largest body of a set secret request. values are
+// JSON strings so escaping may double their size.
+const maxSecretBody = 2*secrets.MaxValue + 1024
+
+// WithSecrets lets jobs use the secrets in store. without it the
+// secret routes fail and start requests can't reference secrets.
+func WithSecrets(store *secrets.Store) Option {
+	return func(s *Server) {
+		s.secrets = store
+	}
+}
+
+// secretsDisabled is returned while the server has no secret store
+func secretsDisabled() *api.Error {
+	return newError(http.StatusNotImplemented, api.CodeUnavailable, "secrets are not enabled on this server")
+}
+
+// secretError maps an error from the secret store to an api error
+func secretError(err error) *api.Error {
+	if errors.Is(err, secrets.ErrNotFound) {
+		return newError(http.StatusNotFound, api.CodeSecretNotFound, err.Error())
+	}
+	return newError(http.StatusInternalServerError, api.CodeInternal, err.Error())
+}
+
+// listSecrets lists the secrets of the namespace of the route without
+// their values
+func (s *Server) listSecrets(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if s.secrets == nil {
+		sendError(w, secretsDisabled())
+		return
+	}
+	sendJSON(w, http.StatusOK, api.SecretList{Secrets: s.secrets.List(routeNamespace(p))})
+}
+
+// setSecret creates or replaces a secret in the namespace of the route.
+// the response describes the secret but never holds its value.
+func (s *Server) setSecret(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if s.secrets == nil {
+		sendError(w, secretsDisabled())
+		return
+	}
+
+	var req api.SetSecretRequest
+	if e := decodeStrict(w, r, maxSecretBody, &req); e != nil {
+		sendError(w, e)
+		return
+	}
+	if details := secrets.Check(p.ByName("name"), req.Value); details != nil {
+		e := newError(http.StatusUnprocessableEntity, api.CodeInvalidRequest, "invalid secret")
+		e.Details = details
+		sendError(w, e)
+		return
+	}
+
+	secret, err := s.secrets.Set(routeNamespace(p), p.ByName("name"), identity(r), req.Value)
+	if err != nil {
+		sendError(w, secretError(err))
+		return
+	}
+	sendJSON(w, http.StatusOK, secret)
+}
+
+// deleteSecret removes the secret matching name. running jobs keep
+// the value they were given
+func (s *Server) deleteSecret(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if s.secrets == nil {
+		sendError(w, secretsDisabled())
+		return
+	}
+	if err := s.secrets.Delete(routeNamespace(p), p.ByName("name")); err != nil {
+		sendError(w, secretError(err))
+		return
+	}
+	sendJSON(w, http.StatusOK, api.DeleteSecretResponse{Name: p.ByName("name"), Deleted: true})
+}
+
+// resolveSecrets looks up the values of the secrets refs give a job in
+// namespace ns
+func (s *Server) resolveSecrets(ns string, refs []api.SecretRef) ([]worker.Secret, *api.Error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	if s.secrets == nil {
+		return nil, secretsDisabled()
+	}
+
+	list := make([]worker.Secret, 0, len(refs))
+	for i, ref := range refs {
+		value, err := s.secrets.Value(ns, ref.Name)
+		if errors.Is(err, secrets.ErrNotFound) {
+			e := newError(http.StatusUnprocessableEntity, api.CodeSecretNotFound, "secret "+ref.Name+" not found")
+			e.Details = map[string]string{"secrets[" + strconv.Itoa(i) + "].name": ref.Name + " is not a secret in namespace " + ns}
+			return nil, e
+		}
+		if err != nil {
+			return nil, secretError(err)
+		}
+		list = append(list, worker.Secret{Env: ref.Env, Value: value, File: ref.File})
+	}
+	return list, nil
+}
@@ -1,14 +1,25 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
-	"errors"
-	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 
+	"github.com/bradyfontenot/ljw/internal/audit"
+	"github.com/bradyfontenot/ljw/internal/certs"
+	"github.com/bradyfontenot/ljw/internal/metrics"
+	"github.com/bradyfontenot/ljw/internal/namespace"
+	"github.com/bradyfontenot/ljw/internal/policy"
+	"github.com/bradyfontenot/ljw/internal/quota"
+	"github.com/bradyfontenot/ljw/internal/secrets"
+	"github.com/bradyfontenot/ljw/internal/templates"
 	"github.com/bradyfontenot/ljw/internal/worker"
+	"google.golang.org/grpc"
 )
 
 const (
@@ -21,59 +32,160 @@ const (
 // Server implements http server and uses a worker to execute tasks.
 type Server struct {
 	*http.Server
+	// Admin serves operational endpoints such as /metrics without
+	// requiring client certificates. nil unless WithAdmin is used.
+	Admin *http.Server
+	// Unix serves the API on a unix socket for local clients. nil
+	// unless WithUnixSocket is used.
+	Unix           *http.Server
+	unixPath       string
+	unixIdentities map[uint32]string
+	unixMode       os.FileMode
+	unixServerUID  bool
+	// GRPC serves the job API over gRPC. nil unless WithGRPC is used.
+	GRPC     *grpc.Server
+	grpcAddr string
+
 	worker *worker.Worker
+	// policy decides which commands clients may run. nil allows all
+	policy *policy.Policy
+	// namespaces split jobs by team and control who may use them
+	namespaces *namespace.Namespaces
+	// templates are the job templates of every namespace
+	templates *templates.Store
+	// secrets are the secrets jobs may use. nil until WithSecrets is used
+	secrets *secrets.Store
+	// quotas limit the calls and jobs of each identity. nil if not enabled
+	quotas *quota.Quotas
+	// startMu makes the quota check and start of a job one step
+	startMu sync.Mutex
+	// audit records every API action. nil if not enabled
+	audit *audit.Log
+	// certs holds the current server certificate and CA
+	certs *certs.Store
+	// minTLS is the oldest TLS version clients may use
+	minTLS uint16
+	// crl rejects revoked client certificates. nil if not enabled
+	crl       *CRL
+	crlFile   string
+	crlReload time.Duration
+	// admins are the client identities allowed to use admin endpoints
+	admins map[string]bool
+
+	// baseCtx is the parent of every request's context. cancelRequests
+	// ends long-polls and event streams on shutdown
+	baseCtx        context.Context
+	cancelRequests context.CancelFunc
+
+	metrics      *metrics.Registry
+	httpRequests *metrics.CounterVec
+	httpDuration *metrics.HistogramVec
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithPolicy checks every start request against p before the job is created
+func WithPolicy(p *policy.Policy) Option {
+	return func(s *Server) {
+		s.policy = p
+	}
 }
 
 // New creates and returns a new server.
-func New(wkr *worker.Worker) (*Server, error) {
+func New(wkr *worker.Worker, opts ...Option) (*Server, error) {
 
-	// load certs and config TLS for server
-	tlsConfig, err := setupTLS()
+	// load certs. they are reloaded when the files change
+	store, err := certs.Load(certFile, keyFile, caFile, certs.DefaultCheckInterval)
 	if err != nil {
 		return nil, err
 	}
 
-	var s Server
-	s = Server{
-		&http.Server{
-			Addr:    addr,
-			Handler: s.router(),
-			// Generic timeout. Could use header timeout if you want
-			// to set specific read timeout for each handler
-			ReadTimeout:  time.Duration(30 * time.Second),
-			WriteTimeout: time.Duration(30 * time.Second),
-			TLSConfig:    tlsConfig,
-		},
-		wkr,
+	// templates are kept in memory unless WithTemplates is used
+	tmpl, err := templates.Open("")
+	if err != nil {
+		return nil, err
 	}
 
-	return &s, nil
-}
+	s := &Server{
+		worker:       wkr,
+		templates:    tmpl,
+		certs:        store,
+		minTLS:       tls.VersionTLS13,
+		admins:       make(map[string]bool),
+		namespaces:   &namespace.Namespaces{},
+		metrics:      metrics.NewRegistry(),
+		httpRequests: metrics.NewCounterVec("ljw_http_requests_total", "API requests handled.", "method", "route", "code"),
+		httpDuration: metrics.NewHistogramVec("ljw_http_request_duration_seconds", "API request latency.", metrics.DefBuckets, "method", "route"),
+	}
+	s.metrics.Register(wkr.Collectors()...)
+	s.metrics.Register(s.httpRequests, s.httpDuration, s.certExpiry())
+	s.metrics.Register(metrics.ProcessCollectors()...)
 
-// setupTLS sets up Authentication and builds tlsConfig for the server.
-func setupTLS() (*tls.Config, error) {
+	baseCtx, cancel := context.WithCancel(context.Background())
+	s.baseCtx, s.cancelRequests = baseCtx, cancel
 
-	// load certificate authority file
-	caCert, err := ioutil.ReadFile(caFile)
-	if err != nil {
-		return nil, err
+	s.Server = &http.Server{
+		Addr:    addr,
+		Handler: withRequestID(s.router()),
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
+		// Generic timeout. Could use header timeout if you want
+		// to set specific read timeout for each handler
+		ReadTimeout:  time.Duration(30 * time.Second),
+		WriteTimeout: time.Duration(30 * time.Second),
+		TLSConfig:    s.setupTLS(),
 	}
 
-	// create pool for accepted certificate authorities and add ca.
-	caCertPool := x509.NewCertPool()
-	if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
-		return nil, errors.New("failed to append certs from pem")
+	for _, opt := range opts {
+		opt(s)
 	}
 
-	// load certificate and private key files
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-	if err != nil {
-		return nil, err
+	if s.Unix != nil {
+		if err := s.checkUnixIdentities(); err != nil {
+			return nil, err
+		}
 	}
 
-	return &tls.Config{
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		ClientCAs:    caCertPool,
-		Certificates: []tls.Certificate{cert},
-	}, nil
+	if s.crlFile != "" {
+		// checked against the CA in s.certs, which may be replaced
+		crl, err := LoadCRL(s.crlFile, func() *x509.Certificate { return s.certs.CA() })
+		if err != nil {
+			return nil, err
+		}
+		s.crl = crl
+		go crl.Run(baseCtx, s.crlReload)
+	}
+
+	return s, nil
+}
+
+// Shutdown stops the API listener. long-polls and event streams are
+// ended right away so they don't hold up the shutdown, then it waits
+// for other requests to finish until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.cancelRequests()
+	if s.GRPC != nil {
+		s.shutdownGRPC(ctx)
+	}
+	if s.Unix != nil {
+		if err := s.Unix.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	return s.Server.Shutdown(ctx)
+}
+
+// identity returns the common name of the verified client certificate
+// used for the request, or the identity mapped to the uid of a unix
+// socket client. it is "" if the request came over neither.
+func identity(r *http.Request) string {
+	if id, ok := r.Context().Value(peerKey{}).(string); ok {
+		return id
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return r.TLS.PeerCertificates[0].Subject.CommonName
 }
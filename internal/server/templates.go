@@ -0,0 +1,162 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+	"github.com/bradyfontenot/ljw/internal/templates"
+	"github.com/julienschmidt/httprouter"
+)
+
+// WithTemplates keeps job templates in store instead of in memory
+func WithTemplates(store *templates.Store) Option {
+	return func(s *Server) {
+		s.templates = store
+	}
+}
+
+// templateError maps an error from the template store to an api error
+func templateError(err error) *api.Error {
+	switch {
+	case errors.Is(err, templates.ErrNotFound):
+		return newError(http.StatusNotFound, api.CodeTemplateNotFound, err.Error())
+	case errors.Is(err, templates.ErrExists):
+		return newError(http.StatusConflict, api.CodeTemplateExists, err.Error())
+	default:
+		return newError(http.StatusInternalServerError, api.CodeInternal, err.Error())
+	}
+}
+
+// listTemplates lists the templates of the namespace of the route
+func (s *Server) listTemplates(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	resp := api.TemplateList{Templates: []api.Template{}}
+	for _, t := range s.templates.List(routeNamespace(p)) {
+		resp.Templates = append(resp.Templates, templateResource(t))
+	}
+	sendJSON(w, http.StatusOK, resp)
+}
+
+// createTemplate adds a template owned by the caller to the namespace
+// of the route
+func (s *Server) createTemplate(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	var req api.CreateTemplateRequest
+	if e := decodeStrict(w, r, maxStartBody, &req); e != nil {
+		sendError(w, e)
+		return
+	}
+
+	t := api.Template{
+		Name:      req.Name,
+		Namespace: routeNamespace(p),
+		Cmd:       req.Cmd,
+		Params:    req.Params,
+		Env:       req.Env,
+		Labels:    req.Labels,
+		Owner:     identity(r),
+		Created:   time.Now().UTC(),
+	}
+	if req.Limits != nil {
+		t.Limits = *req.Limits
+	}
+	if e := validateTemplate(t); e != nil {
+		sendError(w, e)
+		return
+	}
+
+	if err := s.templates.Create(t); err != nil {
+		sendError(w, templateError(err))
+		return
+	}
+	sendJSON(w, http.StatusCreated, templateResource(t))
+}
+
+// getTemplate returns the template matching name
+func (s *Server) getTemplate(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	t, err := s.templates.Get(routeNamespace(p), p.ByName("name"))
+	if err != nil {
+		sendError(w, templateError(err))
+		return
+	}
+	sendJSON(w, http.StatusOK, templateResource(t))
+}
+
+// deleteTemplate removes the template matching name. jobs started
+// from it are left alone
+func (s *Server) deleteTemplate(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if err := s.templates.Delete(routeNamespace(p), p.ByName("name")); err != nil {
+		sendError(w, templateError(err))
+		return
+	}
+	sendJSON(w, http.StatusOK, api.DeleteTemplateResponse{Name: p.ByName("name"), Deleted: true})
+}
+
+// startTemplate starts a job owned by the caller from the template
+// matching name. the parameters are checked against the template and
+// the command against the policy like any other start request.
+func (s *Server) startTemplate(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	var req api.StartTemplateRequest
+	if e := decodeStrict(w, r, maxStartBody, &req); e != nil {
+		sendError(w, e)
+		return
+	}
+
+	ns := routeNamespace(p)
+	t, err := s.templates.Get(ns, p.ByName("name"))
+	if err != nil {
+		sendError(w, templateError(err))
+		return
+	}
+
+	cmd, env, details := templates.Render(t, req.Params)
+	if details == nil {
+		details = checkEnv(env)
+	}
+	if details != nil {
+		e := newError(http.StatusUnprocessableEntity, api.CodeInvalidParams, "invalid parameters for template "+t.Name)
+		e.Details = details
+		sendError(w, e)
+		return
+	}
+
+	labels := make(map[string]string)
+	for k, v := range t.Labels {
+		labels[k] = v
+	}
+	for k, v := range req.Labels {
+		labels[k] = v
+	}
+	spec, e := s.checkStart(r.Context(), identity(r), r.RemoteAddr, ns, api.StartJobRequest{
+		Cmd:      cmd,
+		Labels:   labels,
+		Webhooks: req.Webhooks,
+		Secrets:  req.Secrets,
+	})
+	if e != nil {
+		sendError(w, e)
+		return
+	}
+	spec.Env = env
+	// checked when the template was created
+	limits, _ := jobLimits(t.Limits)
+	spec.Limits = spec.Limits.Tighter(limits)
+
+	job, e := s.submit(r.Context(), spec)
+	if e != nil {
+		sendError(w, e)
+		return
+	}
+	sendJSON(w, http.StatusCreated, jobResource(job))
+}
+
+// templateResource returns t with nil maps made empty so they encode as {}
+func templateResource(t api.Template) api.Template {
+	t.Cmd = nonNil(t.Cmd)
+	if t.Params == nil {
+		t.Params = map[string]api.TemplateParam{}
+	}
+	t.Env = labels(t.Env)
+	t.Labels = labels(t.Labels)
+	return t
+}
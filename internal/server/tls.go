@@ -0,0 +1,139 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bradyfontenot/ljw/internal/metrics"
+)
+
+// curves offered for key exchange. both are fast and constant time.
+var curves = []tls.CurveID{tls.X25519, tls.CurveP256}
+
+// cipherSuites are used when the floor is lowered to TLS 1.2. only
+// forward secret AEAD suites are allowed. TLS 1.3 suites can't be
+// configured and are all safe.
+var cipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses a TLS version floor given as "1.2" or "1.3"
+func ParseTLSVersion(v string) (uint16, error) {
+	if version, ok := tlsVersions[v]; ok {
+		return version, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q. must be 1.2 or 1.3", v)
+}
+
+func tlsVersionName(version uint16) string {
+	for name, v := range tlsVersions {
+		if v == version {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// WithMinTLSVersion lowers or raises the oldest TLS version clients may
+// use. defaults to TLS 1.3
+func WithMinTLSVersion(version uint16) Option {
+	return func(s *Server) {
+		s.minTLS = version
+	}
+}
+
+// setupTLS builds the tlsConfig for the server. every handshake gets
+// the current certificate and CA, so renewed files take effect without
+// a restart.
+func (s *Server) setupTLS() *tls.Config {
+	return &tls.Config{
+		// lets ListenAndServeTLS and the admin listener find a certificate
+		GetCertificate: s.certs.GetCertificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{
+				MinVersion:       s.minTLS,
+				CurvePreferences: curves,
+				CipherSuites:     cipherSuites,
+				ClientAuth:       tls.RequireAndVerifyClientCert,
+				ClientCAs:        s.certs.Pool(),
+				Certificates:     []tls.Certificate{*s.certs.Certificate()},
+				// checks s.crl at handshake time since options are
+				// applied after the config is built
+				VerifyPeerCertificate: s.verifyPeer,
+			}, nil
+		},
+	}
+}
+
+// ReloadTLS reads the certificate, key and CA files and the CRL again
+func (s *Server) ReloadTLS() error {
+	if err := s.certs.Reload(); err != nil {
+		return err
+	}
+	if s.crl != nil {
+		if _, err := s.crl.Reload(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// certExpiry is a gauge of when the server certificate and CA expire
+func (s *Server) certExpiry() *metrics.GaugeFunc {
+	return metrics.NewGaugeFunc("ljw_tls_cert_expiry_timestamp_seconds", "When the certificates the server uses expire, in unix seconds.", func() map[string]float64 {
+		return map[string]float64{
+			"server": float64(s.certs.Leaf().NotAfter.Unix()),
+			"ca":     float64(s.certs.CA().NotAfter.Unix()),
+		}
+	}, "cert")
+}
+
+// tlsStatus reports the TLS floor and when the server certificate and
+// CA expire
+func (s *Server) tlsStatus(w http.ResponseWriter, r *http.Request) {
+	type certificate struct {
+		Subject   string    `json:"subject"`
+		Serial    string    `json:"serial"`
+		NotBefore time.Time `json:"notBefore"`
+		NotAfter  time.Time `json:"notAfter"`
+		// ExpiresIn is seconds until NotAfter. negative once expired
+		ExpiresIn int64 `json:"expiresIn"`
+	}
+	type response struct {
+		MinVersion string      `json:"minVersion"`
+		Server     certificate `json:"server"`
+		CA         certificate `json:"ca"`
+	}
+
+	describe := func(cert *x509.Certificate) certificate {
+		return certificate{
+			Subject:   cert.Subject.String(),
+			Serial:    cert.SerialNumber.Text(16),
+			NotBefore: cert.NotBefore.UTC(),
+			NotAfter:  cert.NotAfter.UTC(),
+			ExpiresIn: int64(time.Until(cert.NotAfter) / time.Second),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response{
+		MinVersion: tlsVersionName(s.minTLS),
+		Server:     describe(s.certs.Leaf()),
+		CA:         describe(s.certs.CA()),
+	})
+}
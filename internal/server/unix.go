@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultUnixSocketMode lets the server's user and group connect to the
+// unix socket. peer credentials still decide who gets in.
+const DefaultUnixSocketMode os.FileMode = 0660
+
+// WithUnixSocket also serves the API on a unix socket at path for local
+// clients. instead of a client certificate, the uid of the connecting
+// process is looked up in identities to find who it is. processes whose
+// uid isn't in identities are turned away.
+func WithUnixSocket(path string, identities map[uint32]string) Option {
+	return func(s *Server) {
+		s.unixPath = path
+		s.Unix = &http.Server{
+			Handler:     s.Server.Handler,
+			BaseContext: s.Server.BaseContext,
+			ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+				if pc, ok := c.(*peerConn); ok {
+					return context.WithValue(ctx, peerKey{}, pc.identity)
+				}
+				return ctx
+			},
+			ReadTimeout:  s.Server.ReadTimeout,
+			WriteTimeout: s.Server.WriteTimeout,
+		}
+		s.unixIdentities = identities
+	}
+}
+
+// WithUnixSocketMode sets the permissions of the unix socket file.
+// DefaultUnixSocketMode is used without it.
+func WithUnixSocketMode(mode os.FileMode) Option {
+	return func(s *Server) {
+		s.unixMode = mode
+	}
+}
+
+// WithUnixServerUID lets the unix socket identities map the uid the
+// server runs as. jobs run as that uid too, so any job could then call
+// the API as that identity. New refuses the mapping without it.
+func WithUnixServerUID() Option {
+	return func(s *Server) {
+		s.unixServerUID = true
+	}
+}
+
+// checkUnixIdentities returns an error if identities gives the
+// server's own uid an identity and that wasn't allowed
+func (s *Server) checkUnixIdentities() error {
+	uid := uint32(os.Getuid())
+	if id, ok := s.unixIdentities[uid]; ok && !s.unixServerUID {
+		return fmt.Errorf("uid %d=%s is the uid the server runs as. jobs run as it too and could use the unix socket as %s", uid, id, id)
+	}
+	return nil
+}
+
+// ParseUnixIdentity parses a uid to identity mapping given as uid=name
+func ParseUnixIdentity(v string) (uint32, string, error) {
+	i := strings.Index(v, "=")
+	if i < 1 || i == len(v)-1 {
+		return 0, "", fmt.Errorf("%q must be uid=identity", v)
+	}
+	uid, err := strconv.ParseUint(v[:i], 10, 32)
+	if err != nil {
+		return 0, "", fmt.Errorf("%q is not a valid uid", v[:i])
+	}
+	return uint32(uid), v[i+1:], nil
+}
+
+// ServeUnix runs the unix socket listener. it blocks like ListenAndServe.
+// a socket file left behind by an earlier run is replaced.
+func (s *Server) ServeUnix() error {
+	if err := os.Remove(s.unixPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", s.unixPath)
+	if err != nil {
+		return err
+	}
+	mode := s.unixMode
+	if mode == 0 {
+		mode = DefaultUnixSocketMode
+	}
+	if err := os.Chmod(s.unixPath, mode); err != nil {
+		l.Close()
+		return err
+	}
+	return s.Unix.Serve(&peerListener{Listener: l, identities: s.unixIdentities})
+}
+
+type peerKey struct{}
+
+// peerConn is a unix socket connection from a known local user
+type peerConn struct {
+	net.Conn
+	identity string
+}
+
+// peerListener accepts connections from processes whose uid maps to
+// an identity and closes the rest
+type peerListener struct {
+	net.Listener
+	identities map[uint32]string
+}
+
+func (l *peerListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		uid, err := peerUID(c)
+		if err != nil {
+			log.Printf("rejected unix socket client. could not read peer credentials. error: %v", err)
+			c.Close()
+			continue
+		}
+		id, ok := l.identities[uid]
+		if !ok {
+			log.Printf("rejected unix socket client. uid %d has no identity", uid)
+			go reject(c, uid)
+			continue
+		}
+		return &peerConn{Conn: c, identity: id}, nil
+	}
+}
+
+// reject tells a client without an identity why instead of just hanging
+// up. the request is read first, since closing with it unread resets the
+// connection and the client may see that rather than the 403.
+func reject(c net.Conn, uid uint32) {
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(time.Second))
+	if req, err := http.ReadRequest(bufio.NewReader(c)); err == nil {
+		io.Copy(ioutil.Discard, io.LimitReader(req.Body, maxStartBody))
+	}
+	fmt.Fprintf(c, "HTTP/1.1 403 Forbidden\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\nuid %d may not use this socket\n", uid)
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+	"github.com/bradyfontenot/ljw/internal/version"
+	"github.com/bradyfontenot/ljw/internal/worker"
+	"github.com/julienschmidt/httprouter"
+)
+
+// v1Handlers maps the operation of each route in api.Routes to its handler
+func (s *Server) v1Handlers() map[string]httprouter.Handle {
+	return map[string]httprouter.Handle{
+		"listJobs":      s.v1ListJobs,
+		"startJob":      s.v1StartJob,
+		"getJob":        s.v1GetJob,
+		"stopJob":       s.v1StopJob,
+		"getJobLog":     s.v1GetJobLog,
+		"followJob":     s.v1FollowJob,
+		"waitJob":       s.v1WaitJob,
+		"jobWebhooks":   s.v1JobWebhooks,
+		"listArtifacts": s.listArtifacts,
+		"getArtifact":   s.getArtifact,
+		"purgeJob":      s.v1PurgeJob,
+		"pruneJobs":     s.v1PruneJobs,
+		"streamEvents":  s.v1StreamEvents,
+		"readAudit":     s.readAudit,
+		"getQuota":      s.getQuota,
+
+		"listTemplates":  s.listTemplates,
+		"createTemplate": s.createTemplate,
+		"getTemplate":    s.getTemplate,
+		"deleteTemplate": s.deleteTemplate,
+		"startTemplate":  s.startTemplate,
+		"listSecrets":    s.listSecrets,
+		"setSecret":      s.setSecret,
+		"deleteSecret":   s.deleteSecret,
+		"listNamespaces": s.listNamespaces,
+	}
+}
+
+// openAPI serves the OpenAPI document of the v1 API
+func (s *Server) openAPI(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	sendJSON(w, http.StatusOK, api.OpenAPI(version.Version))
+}
+
+// v1ListJobs lists the jobs in the namespace of the route, or in every
+// namespace the caller can read
+func (s *Server) v1ListJobs(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	namespaces := s.readable(r, p)
+	resp := api.JobList{Jobs: []api.Job{}}
+	for _, job := range s.worker.Jobs() {
+		if visible(namespaces, job.Namespace) {
+			resp.Jobs = append(resp.Jobs, jobResource(job))
+		}
+	}
+	sendJSON(w, http.StatusOK, resp)
+}
+
+// v1StartJob starts a new job owned by the caller
+func (s *Server) v1StartJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	spec, e := s.startSpec(w, r, routeNamespace(p))
+	if e != nil {
+		sendError(w, e)
+		return
+	}
+
+	job, e := s.submit(r.Context(), spec)
+	if e != nil {
+		sendError(w, e)
+		return
+	}
+	sendJSON(w, http.StatusCreated, jobResource(job))
+}
+
+// v1GetJob returns the job matching id
+func (s *Server) v1GetJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	job, err := s.worker.GetJob(jobKey(p))
+	if err != nil {
+		sendError(w, workerError(err))
+		return
+	}
+	sendJSON(w, http.StatusOK, jobResource(job))
+}
+
+// v1StopJob stops the job matching id if it is running
+func (s *Server) v1StopJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	stopped, err := s.worker.StopJob(jobKey(p))
+	if err != nil {
+		sendError(w, workerError(err))
+		return
+	}
+	sendJSON(w, http.StatusOK, api.StopJobResponse{ID: p.ByName("id"), Stopped: stopped})
+}
+
+// v1GetJobLog returns all output of the job matching id
+func (s *Server) v1GetJobLog(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	job, err := s.worker.GetJob(jobKey(p))
+	if err != nil {
+		sendError(w, workerError(err))
+		return
+	}
+	output, err := s.worker.JobOutput(job.Key())
+	if err != nil {
+		sendError(w, workerError(err))
+		return
+	}
+
+	sendJSON(w, http.StatusOK, api.JobLog{
+		ID:     job.ID,
+		Cmd:    jobResource(job).Cmd,
+		Status: job.Status,
+		Output: strings.Join(output, ""),
+	})
+}
+
+// v1FollowJob long-polls for output written since ?offset=.
+// see followJob for the polling rules.
+func (s *Server) v1FollowJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	offset, e := offsetParam(r)
+	if e != nil {
+		sendError(w, e)
+		return
+	}
+
+	timeout, err := pollTimeout(r)
+	if err != nil {
+		sendError(w, invalid("timeout", err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	job, chunk, err := s.worker.FollowJob(ctx, jobKey(p), offset)
+	if err != nil {
+		sendError(w, workerError(err))
+		return
+	}
+
+	sendJSON(w, http.StatusOK, api.JobOutput{
+		ID:       job.ID,
+		Status:   job.Status,
+		Output:   strings.Join(chunk.Lines, ""),
+		Offset:   chunk.Next,
+		ExitCode: job.ExitCode,
+	})
+}
+
+// v1WaitJob long-polls until the job ends or ?timeout= expires and
+// returns the job either way
+func (s *Server) v1WaitJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	timeout, err := pollTimeout(r)
+	if err != nil {
+		sendError(w, invalid("timeout", err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	job, err := s.worker.WaitJob(ctx, jobKey(p))
+	if err != nil {
+		sendError(w, workerError(err))
+		return
+	}
+	sendJSON(w, http.StatusOK, jobResource(job))
+}
+
+// v1JobWebhooks returns the webhook delivery log of a job
+func (s *Server) v1JobWebhooks(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	deliveries, err := s.worker.JobWebhooks(jobKey(p))
+	if err != nil {
+		sendError(w, workerError(err))
+		return
+	}
+
+	resp := api.WebhookDeliveryList{ID: p.ByName("id"), Deliveries: []api.WebhookDelivery{}}
+	for _, d := range deliveries {
+		resp.Deliveries = append(resp.Deliveries, api.WebhookDelivery{
+			URL:        d.URL,
+			Attempt:    d.Attempt,
+			Time:       d.Time,
+			StatusCode: d.StatusCode,
+			Error:      d.Error,
+			Success:    d.Success,
+		})
+	}
+	sendJSON(w, http.StatusOK, resp)
+}
+
+// v1PurgeJob deletes the record of a job that has ended
+func (s *Server) v1PurgeJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if err := s.worker.RemoveJob(jobKey(p)); err != nil {
+		sendError(w, workerError(err))
+		return
+	}
+	sendJSON(w, http.StatusOK, api.PurgeJobResponse{ID: p.ByName("id"), Purged: true})
+}
+
+// v1PruneJobs removes ended jobs outside a retention policy from the
+// namespaces the caller can write to
+func (s *Server) v1PruneJobs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	policy, e := s.prunePolicy(w, r)
+	if e != nil {
+		sendError(w, e)
+		return
+	}
+
+	resp := api.PruneResponse{Removed: s.worker.Prune(policy, s.writable(r))}
+	if resp.Removed == nil {
+		resp.Removed = []string{}
+	}
+	sendJSON(w, http.StatusOK, resp)
+}
+
+// v1StreamEvents sends job events as server-sent events.
+// see streamEvents for the query parameters.
+func (s *Server) v1StreamEvents(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	s.sendEvents(w, r, p, func(e worker.Event) interface{} {
+		return api.Event{
+			ID:        e.ID,
+			Type:      e.Type,
+			Time:      e.Time,
+			JobID:     e.JobID,
+			Namespace: e.Namespace,
+			Owner:     e.Owner,
+			Labels:    labels(e.Labels),
+			Status:    e.Status,
+			Output:    e.Output,
+			ExitCode:  e.ExitCode,
+			Dropped:   e.Dropped,
+		}
+	})
+}
+
+// jobResource converts a worker job into its api resource
+func jobResource(job worker.Info) api.Job {
+	j := api.Job{
+		ID:         job.ID,
+		Namespace:  job.Namespace,
+		Cmd:        job.Cmd,
+		Owner:      job.Owner,
+		Labels:     labels(job.Labels),
+		Status:     job.Status,
+		ExitCode:   job.ExitCode,
+		Created:    job.Created.UTC(),
+		Redactions: job.Redactions,
+	}
+	j.Cmd = nonNil(j.Cmd)
+	if !job.Started.IsZero() {
+		t := job.Started.UTC()
+		j.Started = &t
+	}
+	if !job.Ended.IsZero() {
+		t := job.Ended.UTC()
+		j.Ended = &t
+	}
+	return j
+}
+
+// labels returns l, or an empty map if it is nil so it encodes as {}
+func labels(l map[string]string) map[string]string {
+	if l == nil {
+		return map[string]string{}
+	}
+	return l
+}
+
+// sendJSON writes v as the JSON body of a response with status
+func sendJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		e := fmt.Errorf("could not marshall json. error: %w", err)
+		sendError(w, newError(http.StatusInternalServerError, api.CodeInternal, e.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
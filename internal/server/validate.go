@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+	"github.com/bradyfontenot/ljw/internal/templates"
+	"github.com/bradyfontenot/ljw/internal/worker"
+)
+
+// limits on start requests
+const (
+	maxStartBody = 64 << 10
+	maxArgs      = 256
+	maxArgLen    = 4096
+	maxLabels    = 64
+	maxLabelLen  = 256
+	maxWebhooks  = 8
+	maxEnv       = 64
+	maxSecrets   = 16
+	maxArtifacts = 16
+)
+
+// validEnv matches the names jobs' environment variables may have
+var validEnv = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedEnv matches the names of variables that change which program
+// runs or what it loads. jobs can't set them, or the policy would
+// check one executable and another would run.
+var reservedEnv = regexp.MustCompile(`^(PATH|IFS|ENV|BASH_ENV|SHELLOPTS|BASHOPTS|PS4|GCONV_PATH|LD_.*|DYLD_.*)$`)
+
+// decodeStrict decodes the JSON body of r into v. the body is capped at
+// max bytes and must hold exactly one object with no unknown fields.
+func decodeStrict(w http.ResponseWriter, r *http.Request, max int64, v interface{}) *api.Error {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, max))
+	if err != nil {
+		if int64(len(body)) >= max {
+			return invalid("body", fmt.Sprintf("request body is larger than %d bytes", max))
+		}
+		return invalid("body", err.Error())
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if err == io.EOF {
+			return invalid("body", "request body is empty")
+		}
+		return invalid("body", err.Error())
+	}
+	if dec.More() {
+		return invalid("body", "request body holds more than one JSON value")
+	}
+	return nil
+}
+
+// validateStart checks a start request and returns a 422 listing every
+// field that is wrong, or nil if the request is fine
+func validateStart(req api.StartJobRequest) *api.Error {
+	details := make(map[string]string)
+
+	switch {
+	case len(req.Cmd) == 0:
+		details["cmd"] = "cmd must not be empty"
+	case len(req.Cmd) > maxArgs:
+		details["cmd"] = fmt.Sprintf("cmd has %d args. the max is %d", len(req.Cmd), maxArgs)
+	case req.Cmd[0] == "":
+		details["cmd[0]"] = "executable must not be empty"
+	}
+	for i, arg := range req.Cmd {
+		if i >= maxArgs {
+			break
+		}
+		if msg := checkString(arg, maxArgLen); msg != "" {
+			details["cmd["+strconv.Itoa(i)+"]"] = msg
+		}
+	}
+
+	if len(req.Labels) > maxLabels {
+		details["labels"] = fmt.Sprintf("%d labels given. the max is %d", len(req.Labels), maxLabels)
+	}
+	for k, v := range req.Labels {
+		if k == "" {
+			details["labels"] = "label keys must not be empty"
+			continue
+		}
+		if msg := checkString(k, maxLabelLen); msg != "" {
+			details["labels"] = "label key " + msg
+		} else if msg := checkString(v, maxLabelLen); msg != "" {
+			details["labels."+k] = msg
+		}
+	}
+
+	if len(req.Webhooks) > maxWebhooks {
+		details["webhooks"] = fmt.Sprintf("%d webhooks given. the max is %d", len(req.Webhooks), maxWebhooks)
+	}
+	for i, hook := range req.Webhooks {
+		if u, err := url.Parse(hook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			details["webhooks["+strconv.Itoa(i)+"]"] = hook + " is not a valid webhook url"
+		}
+	}
+
+	if len(req.Secrets) > maxSecrets {
+		details["secrets"] = fmt.Sprintf("%d secrets given. the max is %d", len(req.Secrets), maxSecrets)
+	}
+	if req.Dir != "" {
+		if msg := checkString(req.Dir, maxArgLen); msg != "" {
+			details["dir"] = msg
+		} else if !filepath.IsAbs(req.Dir) {
+			details["dir"] = req.Dir + " must be an absolute path"
+		}
+	}
+	if len(req.Artifacts) > 0 && req.Dir == "" {
+		details["dir"] = "dir must be set to keep artifacts"
+	}
+	if len(req.Artifacts) > maxArtifacts {
+		details["artifacts"] = fmt.Sprintf("%d artifact patterns given. the max is %d", len(req.Artifacts), maxArtifacts)
+	}
+	for i, pattern := range req.Artifacts {
+		if msg := checkString(pattern, maxArgLen); msg != "" {
+			details["artifacts["+strconv.Itoa(i)+"]"] = msg
+		} else if msg := worker.CheckArtifactPattern(pattern); msg != "" {
+			details["artifacts["+strconv.Itoa(i)+"]"] = msg
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, ref := range req.Secrets {
+		field := "secrets[" + strconv.Itoa(i) + "]"
+		switch {
+		case ref.Name == "":
+			details[field+".name"] = "name must not be empty"
+		case !validEnv.MatchString(ref.Env):
+			details[field+".env"] = ref.Env + " is not a valid environment variable name"
+		case reservedEnv.MatchString(ref.Env):
+			details[field+".env"] = ref.Env + " is reserved and can't be set by a job"
+		case seen[ref.Env]:
+			details[field+".env"] = ref.Env + " is given more than one secret"
+		}
+		seen[ref.Env] = true
+	}
+
+	if len(details) == 0 {
+		return nil
+	}
+	e := newError(http.StatusUnprocessableEntity, api.CodeInvalidCommand, "invalid start request")
+	e.Details = details
+	return e
+}
+
+// validateTemplate checks a new template and returns a 422 listing
+// every field that is wrong, or nil if the template is fine. its
+// command and labels are held to the limits of a start request.
+func validateTemplate(t api.Template) *api.Error {
+	details := make(map[string]string)
+	if e := validateStart(api.StartJobRequest{Cmd: t.Cmd, Labels: t.Labels}); e != nil {
+		for k, v := range e.Details {
+			details[k] = v
+		}
+	}
+	for k, v := range templates.Check(t) {
+		details[k] = v
+	}
+	for k, v := range checkEnv(t.Env) {
+		details[k] = v
+	}
+	if _, d := jobLimits(t.Limits); d != nil {
+		for k, v := range d {
+			details[k] = v
+		}
+	}
+
+	if len(details) == 0 {
+		return nil
+	}
+	e := newError(http.StatusUnprocessableEntity, api.CodeInvalidRequest, "invalid template")
+	e.Details = details
+	return e
+}
+
+// checkEnv describes what is wrong with env by field, or returns nil
+// if none of its names are reserved and its values are fine
+func checkEnv(env map[string]string) map[string]string {
+	details := make(map[string]string)
+	if len(env) > maxEnv {
+		details["env"] = fmt.Sprintf("%d variables given. the max is %d", len(env), maxEnv)
+	}
+	for k, v := range env {
+		if reservedEnv.MatchString(k) {
+			details["env."+k] = k + " is reserved and can't be set by a job"
+		} else if msg := checkString(v, maxArgLen); msg != "" {
+			details["env."+k] = msg
+		}
+	}
+	if len(details) == 0 {
+		return nil
+	}
+	return details
+}
+
+// jobLimits converts l to worker limits, or describes what is wrong
+// with it by field
+func jobLimits(l api.JobLimits) (worker.Limits, map[string]string) {
+	var limits worker.Limits
+	details := make(map[string]string)
+	if l.Timeout != "" {
+		d, err := time.ParseDuration(l.Timeout)
+		if err != nil || d < 0 {
+			details["limits.timeout"] = l.Timeout + " is not a valid duration"
+		}
+		limits.Timeout = d
+	}
+	if l.CPUSeconds < 0 {
+		details["limits.cpuSeconds"] = "must not be negative"
+	}
+	if l.MemoryBytes < 0 {
+		details["limits.memoryBytes"] = "must not be negative"
+	}
+	if len(details) > 0 {
+		return worker.Limits{}, details
+	}
+
+	limits.CPU = time.Duration(l.CPUSeconds * float64(time.Second))
+	limits.Memory = l.MemoryBytes
+	return limits, nil
+}
+
+// checkString describes what is wrong with s, or returns "" if it is
+// no longer than max bytes and free of control characters
+func checkString(s string, max int) string {
+	if len(s) > max {
+		return fmt.Sprintf("is %d bytes. the max is %d", len(s), max)
+	}
+	for _, c := range s {
+		if c < 0x20 || c == 0x7f || (c >= 0x80 && c < 0xa0) {
+			return fmt.Sprintf("contains control character %U", c)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,284 @@
+// Package templates stores job templates: named commands with
+// {{param}} placeholders that are filled in each time a job is started
+// from them. templates belong to a namespace and are kept in a JSON
+// file so they outlive the server.
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+)
+
+var (
+	// ErrNotFound is returned for a template that doesn't exist
+	ErrNotFound = errors.New("template not found")
+	// ErrExists is returned when creating a template whose name is taken
+	ErrExists = errors.New("template already exists")
+)
+
+var (
+	// placeholder matches {{name}} with optional spaces inside the braces
+	placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+	validName   = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]*[a-z0-9])?$`)
+	validParam  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	validEnv    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Store holds the templates of every namespace
+type Store struct {
+	mu sync.RWMutex
+	// file is where templates are saved. "" keeps them in memory only
+	file string
+	// keyed by namespace and then name
+	templates map[string]map[string]api.Template
+}
+
+// Open returns the store kept in file, creating it on the first save.
+// with file "" templates are only kept in memory.
+func Open(file string) (*Store, error) {
+	s := &Store{file: file, templates: make(map[string]map[string]api.Template)}
+	if file == "" {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []api.Template
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("could not parse templates %s. error: %w", file, err)
+	}
+	for _, t := range list {
+		s.put(t)
+	}
+	return s, nil
+}
+
+// put adds t to the map. caller must hold the lock.
+func (s *Store) put(t api.Template) {
+	if s.templates[t.Namespace] == nil {
+		s.templates[t.Namespace] = make(map[string]api.Template)
+	}
+	s.templates[t.Namespace][t.Name] = t
+}
+
+// Create adds t. it must have been checked with Check.
+func (s *Store) Create(t api.Template) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.templates[t.Namespace][t.Name]; ok {
+		return ErrExists
+	}
+	s.put(t)
+	if err := s.save(); err != nil {
+		delete(s.templates[t.Namespace], t.Name)
+		return err
+	}
+	return nil
+}
+
+// Get returns template name of namespace ns
+func (s *Store) Get(ns, name string) (api.Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.templates[ns][name]
+	if !ok {
+		return api.Template{}, ErrNotFound
+	}
+	return t, nil
+}
+
+// List returns the templates of namespace ns in name order
+func (s *Store) List(ns string) []api.Template {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]api.Template, 0, len(s.templates[ns]))
+	for _, t := range s.templates[ns] {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Delete removes template name of namespace ns
+func (s *Store) Delete(ns, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.templates[ns][name]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.templates[ns], name)
+	if err := s.save(); err != nil {
+		s.put(t)
+		return err
+	}
+	return nil
+}
+
+// save writes every template to the file. it writes a temp file and
+// renames it so a crash never leaves a partial file behind.
+// caller must hold the lock.
+func (s *Store) save() error {
+	if s.file == "" {
+		return nil
+	}
+
+	list := []api.Template{}
+	for _, ns := range s.templates {
+		for _, t := range ns {
+			list = append(list, t)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Namespace != list[j].Namespace {
+			return list[i].Namespace < list[j].Namespace
+		}
+		return list[i].Name < list[j].Name
+	})
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.file), 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.file), ".templates-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.file)
+}
+
+// Check returns what is wrong with t by field, or nil if it is fine.
+// every placeholder must be a declared parameter.
+func Check(t api.Template) map[string]string {
+	details := make(map[string]string)
+
+	if !validName.MatchString(t.Name) {
+		details["name"] = t.Name + " is not a valid template name. use lowercase letters, digits, ., _ and -"
+	}
+	for name, p := range t.Params {
+		if !validParam.MatchString(name) {
+			details["params."+name] = name + " is not a valid parameter name"
+			continue
+		}
+		if p.Pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + p.Pattern + ")$")
+		if err != nil {
+			details["params."+name+".pattern"] = err.Error()
+		} else if p.Default != nil && !re.MatchString(*p.Default) {
+			details["params."+name+".default"] = "default doesn't match the pattern"
+		}
+	}
+
+	for i, arg := range t.Cmd {
+		if name := undeclared(t, arg); name != "" {
+			details["cmd["+strconv.Itoa(i)+"]"] = "{{" + name + "}} is not a parameter of the template"
+		}
+	}
+	for k, v := range t.Env {
+		if !validEnv.MatchString(k) {
+			details["env"] = k + " is not a valid environment variable name"
+		} else if name := undeclared(t, v); name != "" {
+			details["env."+k] = "{{" + name + "}} is not a parameter of the template"
+		}
+	}
+
+	if len(details) == 0 {
+		return nil
+	}
+	return details
+}
+
+// undeclared returns the first placeholder in s that isn't a parameter of t
+func undeclared(t api.Template, s string) string {
+	for _, m := range placeholder.FindAllStringSubmatch(s, -1) {
+		if _, ok := t.Params[m[1]]; !ok {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// Render returns the command and env of t with the placeholders filled
+// in from params and the defaults. if params are missing, unknown or
+// don't match their pattern it returns what is wrong by parameter.
+func Render(t api.Template, params map[string]string) ([]string, map[string]string, map[string]string) {
+	details := make(map[string]string)
+	values := make(map[string]string)
+
+	for name := range params {
+		if _, ok := t.Params[name]; !ok {
+			details["params."+name] = name + " is not a parameter of template " + t.Name
+		}
+	}
+	for name, p := range t.Params {
+		v, ok := params[name]
+		if !ok {
+			if p.Default == nil {
+				details["params."+name] = name + " is required"
+				continue
+			}
+			v = *p.Default
+		}
+		if p.Pattern != "" {
+			if re, err := regexp.Compile("^(?:" + p.Pattern + ")$"); err == nil && !re.MatchString(v) {
+				details["params."+name] = fmt.Sprintf("%s %q does not match %s", name, v, p.Pattern)
+				continue
+			}
+		}
+		values[name] = v
+	}
+	if len(details) > 0 {
+		return nil, nil, details
+	}
+
+	fill := func(s string) string {
+		return placeholder.ReplaceAllStringFunc(s, func(m string) string {
+			return values[placeholder.FindStringSubmatch(m)[1]]
+		})
+	}
+	cmd := make([]string, len(t.Cmd))
+	for i, arg := range t.Cmd {
+		cmd[i] = fill(arg)
+	}
+	var env map[string]string
+	if len(t.Env) > 0 {
+		env = make(map[string]string, len(t.Env))
+		for k, v := range t.Env {
+			env[k] = fill(v)
+		}
+	}
+	return cmd, env, nil
+}
@@ -0,0 +1,126 @@
+package templates
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"testing"
+
+	"github.com/bradyfontenot/ljw/internal/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func greet() api.Template {
+	hello := "hello"
+	return api.Template{
+		Name:      "greet",
+		Namespace: "default",
+		Cmd:       []string{"sh", "-c", "echo {{greeting}} $WHO"},
+		Params: map[string]api.TemplateParam{
+			"greeting": {Default: &hello, Pattern: "[a-z]+"},
+			"who":      {},
+		},
+		Env: map[string]string{"WHO": "{{ who }}"},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	assert.Nil(t, Check(greet()))
+
+	bad := "x"
+	tests := []struct {
+		name  string
+		edit  func(*api.Template)
+		field string
+	}{
+		{"name", func(t *api.Template) { t.Name = "Greet" }, "name"},
+		{"undeclared cmd param", func(t *api.Template) { t.Cmd = []string{"echo", "{{nope}}"} }, "cmd[1]"},
+		{"undeclared env param", func(t *api.Template) { t.Env = map[string]string{"A": "{{nope}}"} }, "env.A"},
+		{"env name", func(t *api.Template) { t.Env = map[string]string{"1A": ""} }, "env"},
+		{"param name", func(t *api.Template) { t.Params["a-b"] = api.TemplateParam{} }, "params.a-b"},
+		{"param pattern", func(t *api.Template) { t.Params["n"] = api.TemplateParam{Pattern: "("} }, "params.n.pattern"},
+		{"default against pattern", func(t *api.Template) { t.Params["n"] = api.TemplateParam{Default: &bad, Pattern: "[0-9]+"} }, "params.n.default"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl := greet()
+			tc.edit(&tmpl)
+			assert.Contains(t, Check(tmpl), tc.field)
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	t.Run("test params and defaults are filled in", func(t *testing.T) {
+		cmd, env, details := Render(greet(), map[string]string{"who": "world"})
+		assert.Nil(t, details)
+		assert.Equal(t, []string{"sh", "-c", "echo hello $WHO"}, cmd)
+		assert.Equal(t, map[string]string{"WHO": "world"}, env)
+
+		cmd, _, _ = Render(greet(), map[string]string{"who": "world", "greeting": "hi"})
+		assert.Equal(t, []string{"sh", "-c", "echo hi $WHO"}, cmd)
+	})
+
+	t.Run("test values aren't expanded again", func(t *testing.T) {
+		_, env, details := Render(greet(), map[string]string{"who": "{{greeting}}"})
+		assert.Nil(t, details)
+		assert.Equal(t, map[string]string{"WHO": "{{greeting}}"}, env)
+	})
+
+	t.Run("test params are checked", func(t *testing.T) {
+		_, _, details := Render(greet(), nil)
+		assert.Equal(t, map[string]string{"params.who": "who is required"}, details)
+
+		_, _, details = Render(greet(), map[string]string{"who": "x", "extra": "y"})
+		assert.Equal(t, map[string]string{"params.extra": "extra is not a parameter of template greet"}, details)
+
+		_, _, details = Render(greet(), map[string]string{"who": "x", "greeting": "HI"})
+		assert.Equal(t, map[string]string{"params.greeting": `greeting "HI" does not match [a-z]+`}, details)
+	})
+}
+
+func TestStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "templates.json")
+	s, err := Open(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	nap := api.Template{Name: "nap", Namespace: "infra", Cmd: []string{"sleep", "1"}}
+	for _, tmpl := range []api.Template{greet(), nap} {
+		if err := s.Create(tmpl); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	t.Run("test create, get and list", func(t *testing.T) {
+		assert.Equal(t, ErrExists, s.Create(greet()))
+
+		got, err := s.Get("default", "greet")
+		assert.NoError(t, err)
+		assert.Equal(t, greet(), got)
+
+		_, err = s.Get("default", "nap")
+		assert.Equal(t, ErrNotFound, err, "templates belong to their namespace")
+		assert.Len(t, s.List("default"), 1)
+		assert.Len(t, s.List("infra"), 1)
+	})
+
+	t.Run("test templates are saved", func(t *testing.T) {
+		reopened, err := Open(file)
+		assert.NoError(t, err)
+		assert.Equal(t, s.List("default"), reopened.List("default"))
+		assert.Equal(t, s.List("infra"), reopened.List("infra"))
+
+		assert.NoError(t, s.Delete("default", "greet"))
+		assert.Equal(t, ErrNotFound, s.Delete("default", "greet"))
+		reopened, _ = Open(file)
+		assert.Len(t, reopened.List("default"), 0)
+	})
+
+	t.Run("test a bad file is rejected", func(t *testing.T) {
+		ioutil.WriteFile(file, []byte(`{`), 0600)
+		_, err := Open(file)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,33 @@
+// Package version holds build information set at link time, e.g.
+//
+//	go build -ldflags "-X github.com/bradyfontenot/ljw/internal/version.Version=v1.2.0 \
+//		-X github.com/bradyfontenot/ljw/internal/version.Commit=$(git rev-parse HEAD) \
+//		-X github.com/bradyfontenot/ljw/internal/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import "runtime"
+
+// set with -ldflags -X
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build info of the running binary
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+	}
+}
@@ -0,0 +1,259 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// ErrArtifactsDisabled is returned by StartJob for a spec with
+// artifacts when the worker has nowhere to keep them
+var ErrArtifactsDisabled = errors.New("artifacts are not enabled on this worker")
+
+// ErrArtifactNotFound matches errors for paths that aren't artifacts of a job
+var ErrArtifactNotFound = errors.New("artifact not found")
+
+// ArtifactConfig says where artifacts are kept and how much each job
+// may keep. zero limits don't limit.
+type ArtifactConfig struct {
+	// Dir holds a directory of artifacts per job
+	Dir string
+	// MaxBytes caps the total size of a job's artifacts
+	MaxBytes int64
+	// MaxFiles caps how many files a job keeps
+	MaxFiles int
+}
+
+// Artifact is a file collected from a job's working directory
+type Artifact struct {
+	// Path is relative to the working directory, with / separators
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// WithArtifacts lets jobs keep files from their working directory
+// once they end
+func WithArtifacts(cfg ArtifactConfig) Option {
+	return func(wkr *Worker) {
+		wkr.artifacts = &cfg
+	}
+}
+
+// JobArtifacts returns the artifacts collected from job matching id
+func (wkr *Worker) JobArtifacts(id string) ([]Artifact, error) {
+	wkr.RLock()
+	job, ok := wkr.jobs[id]
+	wkr.RUnlock()
+	if !ok {
+		return nil, notFoundError(id)
+	}
+
+	job.RLock()
+	defer job.RUnlock()
+	return append([]Artifact(nil), job.artifacts...), nil
+}
+
+// OpenArtifact opens artifact p of job matching id
+func (wkr *Worker) OpenArtifact(id, p string) (*os.File, Artifact, error) {
+	list, err := wkr.JobArtifacts(id)
+	if err != nil {
+		return nil, Artifact{}, err
+	}
+	if wkr.artifacts == nil {
+		list = nil
+	}
+	for _, a := range list {
+		if a.Path != p {
+			continue
+		}
+		f, err := os.Open(filepath.Join(wkr.artifactDir(id), filepath.FromSlash(a.Path)))
+		if os.IsNotExist(err) {
+			break
+		}
+		return f, a, err
+	}
+	return nil, Artifact{}, fmt.Errorf("%s of job %s: %w", p, id, ErrArtifactNotFound)
+}
+
+// artifactDir is where the artifacts of the job with key are kept
+func (wkr *Worker) artifactDir(key string) string {
+	return filepath.Join(wkr.artifacts.Dir, filepath.FromSlash(key))
+}
+
+// removeArtifacts deletes the artifacts of the job with key
+func (wkr *Worker) removeArtifacts(key string) {
+	if wkr.artifacts == nil {
+		return
+	}
+	if err := os.RemoveAll(wkr.artifactDir(key)); err != nil {
+		log.Printf("could not remove artifacts of job %s. error: %v", key, err)
+	}
+}
+
+// collectArtifacts copies the files matching patterns in workDir to
+// dest, keeping within the limits of cfg. workDir is checked against
+// roots again since the job may have replaced it with a symlink. it
+// returns what it copied and why each file it skipped couldn't be kept.
+func collectArtifacts(workDir string, roots, patterns []string, dest string, cfg ArtifactConfig) ([]Artifact, []string) {
+	var problems []string
+	root, err := inRoots(roots, workDir)
+	if err != nil {
+		return nil, []string{"from " + workDir + ". " + err.Error()}
+	}
+	// drop artifacts of an earlier job that had the same id
+	if err := os.RemoveAll(dest); err != nil {
+		return nil, []string{"from " + workDir + ". " + err.Error()}
+	}
+
+	// a file matched by several patterns is copied once
+	files := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(filepath.Join(root, filepath.FromSlash(pattern)))
+		for _, m := range matches {
+			filepath.Walk(m, func(p string, info os.FileInfo, err error) error {
+				// symlinks and devices aren't followed or copied
+				if err == nil && info.Mode().IsRegular() {
+					files[p] = true
+				}
+				return nil
+			})
+		}
+	}
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var list []Artifact
+	total := int64(0)
+	for _, p := range paths {
+		rel, ok := within(root, p)
+		if !ok {
+			problems = append(problems, p+". it is outside the working directory")
+			continue
+		}
+		if cfg.MaxFiles > 0 && len(list) >= cfg.MaxFiles {
+			problems = append(problems, fmt.Sprintf("%s. jobs may keep %d files", rel, cfg.MaxFiles))
+			continue
+		}
+
+		size, err := copyArtifact(root, p, filepath.Join(dest, filepath.FromSlash(rel)), cfg.MaxBytes-total, cfg.MaxBytes > 0)
+		if err != nil {
+			problems = append(problems, rel+". "+err.Error())
+			continue
+		}
+		total += size
+		list = append(list, Artifact{Path: rel, Size: size})
+	}
+	return list, problems
+}
+
+// within returns p relative to root with / separators, and whether p
+// is really inside root once symlinks in its directories are resolved
+func within(root, p string) (string, bool) {
+	dir, err := filepath.EvalSymlinks(filepath.Dir(p))
+	if err != nil {
+		return "", false
+	}
+	p = filepath.Join(dir, filepath.Base(p))
+	if !inside(root, p) {
+		return "", false
+	}
+	rel, _ := filepath.Rel(root, p)
+	return filepath.ToSlash(rel), true
+}
+
+// copyArtifact copies src to dst and returns its size. with limited
+// set files larger than max bytes are not copied. the job may still be
+// swapping files and directories under root, so src is opened without
+// following a symlink and what was opened is checked to be a regular
+// file inside root before anything is read.
+func copyArtifact(root, src, dst string, max int64, limited bool) (int64, error) {
+	in, err := os.OpenFile(src, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if !info.Mode().IsRegular() {
+		return 0, errors.New("it is not a regular file")
+	}
+	if !openedInside(root, src, info) {
+		return 0, errors.New("it is outside the working directory")
+	}
+	if limited && info.Size() > max {
+		return 0, fmt.Errorf("it is %d bytes and only %d are left of the job's artifact limit", info.Size(), max)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return 0, err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	// the file may grow while it is copied, so the limit is enforced
+	// on what is read too
+	var r io.Reader = in
+	if limited {
+		r = io.LimitReader(in, max+1)
+	}
+	n, err := io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && limited && n > max {
+		err = fmt.Errorf("it grew past the job's artifact limit while being copied")
+	}
+	if err != nil {
+		os.Remove(dst)
+		return 0, err
+	}
+	return n, nil
+}
+
+// openedInside reports whether info, the stat of a file opened at p,
+// is the file p now resolves to inside root. a directory swapped for a
+// symlink between the walk and the open makes them differ.
+func openedInside(root, p string, info os.FileInfo) bool {
+	real, err := realPath(p)
+	if err != nil || !inside(root, real) {
+		return false
+	}
+	now, err := os.Lstat(real)
+	return err == nil && os.SameFile(info, now)
+}
+
+// CheckArtifactPattern describes what is wrong with an artifact glob,
+// or returns "" if it is fine. patterns are relative to the working
+// directory and may not leave it.
+func CheckArtifactPattern(pattern string) string {
+	switch {
+	case pattern == "":
+		return "pattern must not be empty"
+	case path.IsAbs(pattern) || filepath.IsAbs(pattern):
+		return pattern + " must be relative to the working directory"
+	}
+	for _, part := range strings.Split(pattern, "/") {
+		if part == ".." {
+			return pattern + " must not leave the working directory"
+		}
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return pattern + " is not a valid glob"
+	}
+	return ""
+}
@@ -0,0 +1,219 @@
+package worker
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// event types
+const (
+	EventCreated  = "created"
+	EventQueued   = "queued"
+	EventStarted  = "started"
+	EventOutput   = "output"
+	EventStopped  = "stopped"
+	EventFinished = "finished"
+	// EventGap is sent in place of events that dropped out of the buffer
+	// before they were read. Dropped lists their kinds.
+	EventGap = "gap"
+)
+
+// kinds of events listed in Dropped
+const (
+	KindLifecycle = "lifecycle"
+	KindOutput    = "output"
+)
+
+// eventBufferSize is how many past events of each kind are kept for
+// cursors to resume from. output is buffered apart from the lifecycle
+// events so a chatty job can't push them out.
+const eventBufferSize = 4096
+
+// Event is a change in a job's lifecycle.
+// ID increases by one for every event and serves as the resume cursor.
+type Event struct {
+	ID        uint64            `json:"id"`
+	Type      string            `json:"type"`
+	Time      time.Time         `json:"time"`
+	JobID     string            `json:"jobId"`
+	Namespace string            `json:"namespace"`
+	Owner     string            `json:"owner,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Status    string            `json:"status,omitempty"`
+	Output    string            `json:"output,omitempty"`
+	ExitCode  *int              `json:"exitCode,omitempty"`
+	// Dropped lists the kinds of events lost to a gap
+	Dropped []string `json:"dropped,omitempty"`
+}
+
+// EventFilter selects events. empty fields match everything and
+// every label given must be present on the job with the same value.
+type EventFilter struct {
+	// JobID is the key of a job, see Key
+	JobID string
+	// Namespaces are the namespaces whose events match
+	Namespaces []string
+	Owner      string
+	Labels     map[string]string
+}
+
+func (f EventFilter) match(e Event) bool {
+	if f.JobID != "" && f.JobID != Key(e.Namespace, e.JobID) {
+		return false
+	}
+	if len(f.Namespaces) > 0 && !contains(f.Namespaces, e.Namespace) {
+		return false
+	}
+	if f.Owner != "" && f.Owner != e.Owner {
+		return false
+	}
+	for k, v := range f.Labels {
+		if lv, ok := e.Labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ring keeps the most recent eventBufferSize events of one kind in id
+// order
+type ring struct {
+	buf []Event
+	// next is where the oldest event is, and the next one goes, once
+	// the buffer is full
+	next int
+	// dropped is the id of the newest event overwritten
+	dropped uint64
+}
+
+func (r *ring) add(e Event) {
+	if len(r.buf) < eventBufferSize {
+		r.buf = append(r.buf, e)
+		return
+	}
+	r.dropped = r.buf[r.next].ID
+	r.buf[r.next] = e
+	r.next = (r.next + 1) % eventBufferSize
+}
+
+// after appends the events with an id greater than cursor to events
+func (r *ring) after(cursor uint64, events []Event) []Event {
+	at := func(i int) Event {
+		return r.buf[(r.next+i)%len(r.buf)]
+	}
+	for i := sort.Search(len(r.buf), func(i int) bool { return at(i).ID > cursor }); i < len(r.buf); i++ {
+		events = append(events, at(i))
+	}
+	return events
+}
+
+// eventBus keeps the most recent lifecycle and output events in ring
+// buffers and wakes subscribers whenever a new one is published.
+type eventBus struct {
+	seq       uint64
+	lifecycle ring
+	output    ring
+	notify    chan struct{}
+	sync.Mutex
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		notify: make(chan struct{}),
+	}
+}
+
+// publish assigns e the next id and stores it
+func (b *eventBus) publish(e Event) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.seq++
+	e.ID = b.seq
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	if e.Type == EventOutput {
+		b.output.add(e)
+	} else {
+		b.lifecycle.add(e)
+	}
+
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// since returns buffered events with an id greater than cursor in
+// order, the kinds of events after cursor that are no longer buffered,
+// the latest id and a channel closed on the next publish.
+func (b *eventBus) since(cursor uint64) ([]Event, []string, uint64, <-chan struct{}) {
+	b.Lock()
+	defer b.Unlock()
+
+	if cursor >= b.seq {
+		return nil, nil, b.seq, b.notify
+	}
+
+	var dropped []string
+	if b.lifecycle.dropped > cursor {
+		dropped = append(dropped, KindLifecycle)
+	}
+	if b.output.dropped > cursor {
+		dropped = append(dropped, KindOutput)
+	}
+
+	events := b.lifecycle.after(cursor, nil)
+	events = b.output.after(cursor, events)
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, dropped, b.seq, b.notify
+}
+
+// LatestEvent returns the id of the most recent event. passing it to
+// Events as the cursor only returns events published after this call.
+func (wkr *Worker) LatestEvent() uint64 {
+	_, _, latest, _ := wkr.events.since(^uint64(0))
+	return latest
+}
+
+// Events returns events published after cursor that match filter.
+// blocks until there is at least one match or ctx is done. the
+// returned cursor is what to pass in on the next call. if events after
+// cursor have dropped out of the buffer the first event returned is a
+// gap, whatever the filter, with the id of cursor.
+func (wkr *Worker) Events(ctx context.Context, cursor uint64, filter EventFilter) ([]Event, uint64) {
+	for {
+		events, dropped, latest, notify := wkr.events.since(cursor)
+
+		var matched []Event
+		if len(dropped) > 0 {
+			matched = append(matched, Event{ID: cursor, Type: EventGap, Time: time.Now().UTC(), Dropped: dropped})
+		}
+		for _, e := range events {
+			if filter.match(e) {
+				matched = append(matched, e)
+			}
+		}
+		cursor = latest
+		if len(matched) > 0 {
+			return matched, cursor
+		}
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil, cursor
+		}
+	}
+}
@@ -2,10 +2,16 @@ package worker
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"sort"
 	"sync"
 	"syscall"
+	"time"
+
+	"github.com/bradyfontenot/ljw/internal/redact"
 )
 
 // status values
@@ -18,26 +24,139 @@ const (
 )
 
 type job struct {
-	cmd    []string
-	status string
-	output []string
-	pid    int
+	id        string
+	namespace string
+	owner     string
+	labels    map[string]string
+	cmd       []string
+	// env is added to the environment of the process. it isn't stored
+	env map[string]string
+	// secrets are given to the process and hidden in its output. they
+	// are dropped once the job ends
+	secrets []Secret
+	// rules hide credentials in the output. redactions counts the
+	// matches they replaced
+	rules      *redact.Rules
+	redactions int
+	// dir is the working directory of the process
+	dir string
+	// roots are the job roots dir must still be inside when artifacts
+	// are collected
+	roots []string
+	// patterns are globs of files in dir copied to artifactDir, within
+	// the limits of artifactCfg, when the process ends
+	patterns    []string
+	artifactDir string
+	artifactCfg ArtifactConfig
+	artifacts   []Artifact
+	status      string
+	output      []string
+	pid         int
+	exitCode    int
+	created     time.Time
+	started     time.Time
+	ended       time.Time
+	// cpu time used by the process once it has ended
+	userTime time.Duration
+	sysTime  time.Duration
+	limits   Limits
+	// timer stops the job when it reaches its timeout
+	timer *time.Timer
+	// notify is closed and replaced whenever output or status changes
+	// so followers can block until there is something new to read.
+	notify chan struct{}
+	// done is closed once the job reaches a terminal status
+	done chan struct{}
+	// events receives lifecycle events for the job
+	events  *eventBus
+	metrics *workerMetrics
+	// cpu is charged with the cpu time of the process when it ends
+	cpu *cpuLedger
+	// maxRunning is the limit of the owner's running jobs a queued
+	// job waits on
+	maxRunning int
+	// webhooks are urls to notify when the job ends
+	webhooks   []string
+	deliveries []Delivery
+	// onFinish, if set, is run on its own goroutine once the job ends
+	onFinish func()
 	sync.RWMutex
 }
 
-func newJob(cmd []string) *job {
+func newJob(id string, spec Spec, events *eventBus, m *workerMetrics) *job {
+	ns := spec.Namespace
+	if ns == "" {
+		ns = DefaultNamespace
+	}
 	return &job{
-		cmd:    cmd,
-		status: queued,
+		id:        id,
+		namespace: ns,
+		limits:    spec.Limits,
+		owner:     spec.Owner,
+		labels:    spec.Labels,
+		cmd:       spec.Cmd,
+		env:       spec.Env,
+		secrets:   spec.Secrets,
+		dir:       spec.Dir,
+		patterns:  spec.Artifacts,
+		status:    queued,
+		created:   time.Now(),
+		notify:    make(chan struct{}),
+		done:      make(chan struct{}),
+		events:    events,
+		metrics:   m,
+		webhooks:  spec.Webhooks,
 	}
 }
 
+// environ returns env as sorted KEY=value pairs
+func environ(env map[string]string) []string {
+	list := make([]string, 0, len(env))
+	for k, v := range env {
+		list = append(list, k+"="+v)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// isTerminal reports whether status is one a job can no longer leave.
+func isTerminal(status string) bool {
+	return status == finished || status == canceled || status == failed
+}
+
 // start handles running of linux command processes
 func (j *job) start() {
 
 	cmd := exec.Command(j.cmd[0], j.cmd[1:]...)
 	// Create a Process Group ID so call to terminate also kills child process
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Dir = j.dir
+
+	j.RLock()
+	secrets := j.secrets
+	j.RUnlock()
+	rules := j.rules.WithValues(secretValues(secrets))
+
+	env, files, err := secretEnv(secrets)
+	if err != nil {
+		j.Lock()
+		j.appendOutput("Error: could not write secret files. " + err.Error())
+		j.status = failed
+		j.exitCode = 126
+		j.finish()
+		j.Unlock()
+		return
+	}
+	// secrets win over variables of the same name
+	for k, v := range j.env {
+		if _, ok := env[k]; !ok {
+			env[k] = v
+		}
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), environ(env)...)
+	}
+	j.limits.withLimits(cmd)
 
 	cmdReader, err := cmd.StdoutPipe()
 	cmd.Stderr = cmd.Stdout
@@ -48,9 +167,13 @@ func (j *job) start() {
 	go func() {
 		sc <- true
 		for scanner.Scan() {
-			line := scanner.Text()
+			line, counts := rules.Apply(scanner.Text())
 			j.Lock()
-			j.output = append(j.output, line+"\n")
+			for name, n := range counts {
+				j.redactions += n
+				j.metrics.redactions.Add(float64(n), name)
+			}
+			j.appendOutput(line + "\n")
 			j.Unlock()
 		}
 		done <- true
@@ -59,47 +182,82 @@ func (j *job) start() {
 	<-sc
 	err = cmd.Start()
 	if err != nil {
+		removeFiles(files)
 		j.Lock()
-		j.output = append(j.output, err.Error())
+		j.appendOutput(err.Error())
 		j.status = failed
+		// mirror the shell: 127 when the command is missing, 126 otherwise
+		j.exitCode = 126
+		if errors.Is(err, exec.ErrNotFound) {
+			j.exitCode = 127
+		}
+		j.finish()
 		j.Unlock()
 		return
 	}
 
 	j.Lock()
 	j.status = running
+	j.started = time.Now()
+	j.metrics.started.Inc()
 	// store the Pid so stop() can be called later if needed.
 	j.pid = cmd.Process.Pid
+	j.applyLimits()
+	j.emit(EventStarted, "")
+	j.broadcast()
 	j.Unlock()
 
 	go func() {
 		<-done
 		err = cmd.Wait()
+		removeFiles(files)
+
+		var artifacts []Artifact
+		var problems []string
+		if len(j.patterns) > 0 {
+			artifacts, problems = collectArtifacts(j.dir, j.roots, j.patterns, j.artifactDir, j.artifactCfg)
+		}
 
 		j.Lock()
 		defer j.Unlock()
 
 		if err != nil {
-			j.output = append(j.output, "Error: "+err.Error())
+			j.appendOutput("Error: " + err.Error())
+		}
+		j.artifacts = artifacts
+		for _, p := range problems {
+			j.appendOutput("Error: could not keep artifact " + p + "\n")
 		}
 
-		if cmd.ProcessState.ExitCode() == -1 {
-			j.status = canceled
-			return
+		j.userTime = cmd.ProcessState.UserTime()
+		j.sysTime = cmd.ProcessState.SystemTime()
+
+		// report signaled processes the way a shell would (128 + signal)
+		j.exitCode = cmd.ProcessState.ExitCode()
+		if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			j.exitCode = 128 + int(ws.Signal())
 		}
 
-		if cmd.ProcessState.Success() {
+		switch {
+		case cmd.ProcessState.ExitCode() == -1:
+			j.status = canceled
+		case cmd.ProcessState.Success():
 			j.status = finished
-		} else {
+		default:
 			j.status = failed
 		}
+		j.finish()
 	}()
 
 }
 
 // stop kills process if it is running when called and returns true.
-// otherwise simply returns false.
+// a job still queued is canceled instead. otherwise simply returns false.
 func (j *job) stop() (bool, error) {
+	if j.cancel() {
+		return true, nil
+	}
+
 	j.RLock()
 	defer j.RUnlock()
 
@@ -110,10 +268,176 @@ func (j *job) stop() (bool, error) {
 	if err := syscall.Kill(-j.pid, syscall.SIGTERM); err != nil {
 		return false, fmt.Errorf("could not terminate process. error: %v", err)
 	}
+	j.emit(EventStopped, "")
 
 	return true, nil
 }
 
+// cancel ends the job without running it if it is still queued and
+// reports whether it was
+func (j *job) cancel() bool {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.status != queued {
+		return false
+	}
+	j.status = canceled
+	// report it like a job stopped with SIGTERM
+	j.exitCode = 128 + int(syscall.SIGTERM)
+	j.emit(EventStopped, "")
+	j.finish()
+	return true
+}
+
+// broadcast wakes everyone waiting on the current notify channel.
+// caller must hold the write lock.
+func (j *job) broadcast() {
+	close(j.notify)
+	j.notify = make(chan struct{})
+}
+
+// appendOutput stores a line of output and tells followers about it.
+// caller must hold the write lock.
+func (j *job) appendOutput(line string) {
+	j.output = append(j.output, line)
+	j.metrics.outputBytes.Add(float64(len(line)))
+	j.emit(EventOutput, line)
+	j.broadcast()
+}
+
+// finish announces that the job has reached its terminal status.
+// caller must hold the write lock and have set status and exitCode.
+func (j *job) finish() {
+	j.ended = time.Now()
+	j.secrets = nil
+	if j.timer != nil {
+		j.timer.Stop()
+	}
+
+	duration := time.Duration(0)
+	if !j.started.IsZero() {
+		duration = j.ended.Sub(j.started)
+	}
+	j.metrics.finished.Inc(j.status)
+	j.metrics.duration.Observe(duration.Seconds(), j.status)
+	j.metrics.cpuSeconds.Add(j.userTime.Seconds(), "user")
+	j.metrics.cpuSeconds.Add(j.sysTime.Seconds(), "system")
+	if j.cpu != nil {
+		j.cpu.charge(j.namespace, j.owner, j.userTime+j.sysTime, j.ended)
+	}
+
+	j.emit(EventFinished, "")
+	j.broadcast()
+	close(j.done)
+	if j.onFinish != nil {
+		go j.onFinish()
+	}
+}
+
+// logDelivery records a webhook delivery attempt
+func (j *job) logDelivery(d Delivery) {
+	j.Lock()
+	defer j.Unlock()
+	j.deliveries = append(j.deliveries, d)
+}
+
+// emit publishes an event of type typ for the job.
+// caller must hold the lock.
+func (j *job) emit(typ string, output string) {
+	if j.events == nil {
+		return
+	}
+
+	e := Event{
+		Type:      typ,
+		JobID:     j.id,
+		Namespace: j.namespace,
+		Owner:     j.owner,
+		Labels:    j.labels,
+		Status:    j.status,
+		Output:    output,
+	}
+	if isTerminal(j.status) {
+		code := j.exitCode
+		e.ExitCode = &code
+	}
+	j.events.publish(e)
+}
+
+// outputSince returns output lines from offset onward along with the
+// job's info and a channel that is closed on the next change.
+// all values are read under one lock so no update can slip in between.
+func (j *job) outputSince(offset int) ([]string, Info, <-chan struct{}) {
+	j.RLock()
+	defer j.RUnlock()
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(j.output) {
+		offset = len(j.output)
+	}
+	lines := make([]string, len(j.output)-offset)
+	copy(lines, j.output[offset:])
+
+	return lines, j.snapshot(), j.notify
+}
+
+// info returns a snapshot of the job
+func (j *job) info() Info {
+	j.RLock()
+	defer j.RUnlock()
+	return j.snapshot()
+}
+
+// snapshot builds the job's info. caller must hold the lock.
+func (j *job) snapshot() Info {
+	info := Info{
+		ID:         j.id,
+		Namespace:  j.namespace,
+		Cmd:        append([]string(nil), j.cmd...),
+		Owner:      j.owner,
+		Status:     j.status,
+		Created:    j.created,
+		Started:    j.started,
+		Ended:      j.ended,
+		Redactions: j.redactions,
+	}
+	if j.labels != nil {
+		info.Labels = make(map[string]string, len(j.labels))
+		for k, v := range j.labels {
+			info.Labels[k] = v
+		}
+	}
+	if isTerminal(j.status) {
+		code := j.exitCode
+		info.ExitCode = &code
+	}
+	return info
+}
+
+// key returns the key the worker knows the job by
+func (j *job) key() string {
+	return Key(j.namespace, j.id)
+}
+
+// Done returns a channel that is closed once the job has ended
+func (j *job) Done() <-chan struct{} {
+	return j.done
+}
+
+// signal sends sig to the job's process group if it is running
+func (j *job) signal(sig syscall.Signal) error {
+	j.RLock()
+	defer j.RUnlock()
+
+	if j.status != running {
+		return nil
+	}
+	return syscall.Kill(-j.pid, sig)
+}
+
 func (j *job) Cmd() []string {
 	j.RLock()
 	defer j.RUnlock()
@@ -126,8 +450,48 @@ func (j *job) Status() string {
 	return j.status
 }
 
+func (j *job) Owner() string {
+	j.RLock()
+	defer j.RUnlock()
+	return j.owner
+}
+
+func (j *job) Deliveries() []Delivery {
+	j.RLock()
+	defer j.RUnlock()
+	deliveries := make([]Delivery, len(j.deliveries))
+	copy(deliveries, j.deliveries)
+	return deliveries
+}
+
+// EndedAt returns when the job ended and false if it is still active
+func (j *job) EndedAt() (time.Time, bool) {
+	j.RLock()
+	defer j.RUnlock()
+	return j.ended, isTerminal(j.status)
+}
+
+// LogBytes returns the size of the job's captured output
+func (j *job) LogBytes() int {
+	j.RLock()
+	defer j.RUnlock()
+	n := 0
+	for _, line := range j.output {
+		n += len(line)
+	}
+	return n
+}
+
 func (j *job) Output() []string {
 	j.RLock()
 	defer j.RUnlock()
 	return j.output
 }
+
+// ExitCode returns the exit code of the process. only meaningful
+// once the job has reached a terminal status.
+func (j *job) ExitCode() int {
+	j.RLock()
+	defer j.RUnlock()
+	return j.exitCode
+}
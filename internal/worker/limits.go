@@ -0,0 +1,152 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Limits cap the resources of a job's process. zero fields are not
+// enforced. CPU and Memory are set as rlimits before the command is
+// executed and are inherited by the processes it starts.
+type Limits struct {
+	// Timeout stops the job once it has run this long
+	Timeout time.Duration
+	// CPU is the cpu time the process may use before the kernel kills it
+	CPU time.Duration
+	// Memory caps the address space of the process in bytes
+	Memory int64
+}
+
+// limitsEnv holds the rlimits the limits shim sets before it executes
+// the job's command, as resource=cur:max pairs separated by commas
+const limitsEnv = "_LJW_RLIMITS"
+
+// the limits shim runs in place of the job's process when the worker
+// starts a command with rlimits. go can't run code between fork and
+// exec, so the worker executes its own binary, which sets the rlimits
+// on itself and then executes the command. the command never runs
+// without its limits.
+func init() {
+	if v, ok := os.LookupEnv(limitsEnv); ok {
+		os.Exit(limitsShim(v, os.Args[1:]))
+	}
+}
+
+// limitsShim sets the rlimits in v on this process and replaces it with
+// args[0] run as args[1:]. it only returns, with the exit code to use,
+// if that fails.
+func limitsShim(v string, args []string) int {
+	os.Unsetenv(limitsEnv)
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "Error: could not limit resources. no command given")
+		return 126
+	}
+	for _, pair := range strings.Split(v, ",") {
+		var resource int
+		var rlim syscall.Rlimit
+		if _, err := fmt.Sscanf(pair, "%d=%d:%d", &resource, &rlim.Cur, &rlim.Max); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not limit resources. %q is not a limit\n", pair)
+			return 126
+		}
+		if err := setRlimit(resource, rlim); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not limit resources. %v\n", err)
+			return 126
+		}
+	}
+	err := syscall.Exec(args[0], args[1:], os.Environ())
+	fmt.Fprintf(os.Stderr, "Error: could not run %s. %v\n", args[0], err)
+	if errors.Is(err, syscall.ENOENT) {
+		return 127
+	}
+	return 126
+}
+
+// withLimits makes cmd start through the limits shim, which sets the
+// rlimits of l before executing the command. cmd must not have been
+// started and is left as is if l sets no rlimits.
+func (l Limits) withLimits(cmd *exec.Cmd) {
+	rlimits := l.rlimits()
+	if len(rlimits) == 0 || cmd.Err != nil {
+		return
+	}
+	resources := make([]int, 0, len(rlimits))
+	for resource := range rlimits {
+		resources = append(resources, resource)
+	}
+	sort.Ints(resources)
+	pairs := make([]string, len(resources))
+	for i, resource := range resources {
+		pairs[i] = fmt.Sprintf("%d=%d:%d", resource, rlimits[resource].Cur, rlimits[resource].Max)
+	}
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, limitsEnv+"="+strings.Join(pairs, ","))
+	// /proc/self/exe is resolved in the forked child, so it is the
+	// worker's binary even if the file has since been replaced
+	cmd.Args = append([]string{cmd.Args[0], cmd.Path}, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+}
+
+// rlimits returns the rlimits to set for l by resource
+func (l Limits) rlimits() map[int]syscall.Rlimit {
+	limits := make(map[int]syscall.Rlimit)
+	if l.CPU > 0 {
+		// the kernel sends SIGXCPU at the soft limit and SIGKILL at the hard one
+		secs := uint64((l.CPU + time.Second - 1) / time.Second)
+		limits[syscall.RLIMIT_CPU] = syscall.Rlimit{Cur: secs, Max: secs + 1}
+	}
+	if l.Memory > 0 {
+		limits[syscall.RLIMIT_AS] = syscall.Rlimit{Cur: uint64(l.Memory), Max: uint64(l.Memory)}
+	}
+	return limits
+}
+
+// applyLimits arms the timeout of the job. its rlimits were set by the
+// limits shim before the command ran.
+// caller must hold the write lock and have started the process.
+func (j *job) applyLimits() {
+	if j.limits.Timeout > 0 {
+		j.timer = time.AfterFunc(j.limits.Timeout, j.timeout)
+	}
+}
+
+// timeout stops the job once it has run for longer than its limit.
+// it gets killGrace to exit before it is killed.
+func (j *job) timeout() {
+	j.Lock()
+	if j.status != running {
+		j.Unlock()
+		return
+	}
+	j.appendOutput(fmt.Sprintf("Error: job exceeded its timeout of %v\n", j.limits.Timeout))
+	j.emit(EventStopped, "")
+	j.Unlock()
+
+	j.signal(syscall.SIGTERM)
+	time.AfterFunc(killGrace, func() {
+		j.signal(syscall.SIGKILL)
+	})
+}
+
+// Tighter returns the lower of l and o for every field. zero fields
+// don't limit, so the other one is used.
+func (l Limits) Tighter(o Limits) Limits {
+	if o.Timeout > 0 && (l.Timeout == 0 || o.Timeout < l.Timeout) {
+		l.Timeout = o.Timeout
+	}
+	if o.CPU > 0 && (l.CPU == 0 || o.CPU < l.CPU) {
+		l.CPU = o.CPU
+	}
+	if o.Memory > 0 && (l.Memory == 0 || o.Memory < l.Memory) {
+		l.Memory = o.Memory
+	}
+	return l
+}
@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"github.com/bradyfontenot/ljw/internal/metrics"
+)
+
+// durationBuckets are job duration histogram bounds in seconds
+var durationBuckets = []float64{.1, .5, 1, 5, 10, 30, 60, 300, 900, 3600, 4 * 3600}
+
+// workerMetrics are counters the worker updates as jobs run
+type workerMetrics struct {
+	started     *metrics.CounterVec
+	finished    *metrics.CounterVec
+	duration    *metrics.HistogramVec
+	outputBytes *metrics.CounterVec
+	cpuSeconds  *metrics.CounterVec
+	redactions  *metrics.CounterVec
+}
+
+func newWorkerMetrics() *workerMetrics {
+	return &workerMetrics{
+		started:     metrics.NewCounterVec("ljw_jobs_started_total", "Jobs whose process was started."),
+		finished:    metrics.NewCounterVec("ljw_jobs_finished_total", "Jobs that reached a terminal status.", "status"),
+		duration:    metrics.NewHistogramVec("ljw_job_duration_seconds", "Run time of ended jobs.", durationBuckets, "status"),
+		outputBytes: metrics.NewCounterVec("ljw_job_output_bytes_total", "Bytes of job output captured."),
+		cpuSeconds:  metrics.NewCounterVec("ljw_job_cpu_seconds_total", "CPU time used by ended job processes.", "mode"),
+		redactions:  metrics.NewCounterVec("ljw_job_output_redactions_total", "Matches of redaction rules hidden in job output.", "rule"),
+	}
+}
+
+// Collectors returns the worker's metrics for registration
+func (wkr *Worker) Collectors() []metrics.Collector {
+	jobs := metrics.NewGaugeFunc("ljw_jobs", "Jobs currently held by the worker by status.", wkr.statusCounts, "status")
+
+	return []metrics.Collector{
+		jobs,
+		wkr.metrics.started,
+		wkr.metrics.finished,
+		wkr.metrics.duration,
+		wkr.metrics.outputBytes,
+		wkr.metrics.cpuSeconds,
+		wkr.metrics.redactions,
+	}
+}
+
+// statusCounts counts jobs by status. every status is always present.
+func (wkr *Worker) statusCounts() map[string]float64 {
+	wkr.RLock()
+	defer wkr.RUnlock()
+
+	counts := map[string]float64{queued: 0, running: 0, finished: 0, canceled: 0, failed: 0}
+	for _, job := range wkr.jobs {
+		counts[job.Status()]++
+	}
+	return counts
+}
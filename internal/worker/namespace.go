@@ -0,0 +1,24 @@
+package worker
+
+import "strings"
+
+// DefaultNamespace holds the jobs started without a namespace
+const DefaultNamespace = "default"
+
+// Key returns the key of job id in namespace ns. jobs in the default
+// namespace are keyed by their id alone, others by "ns/id". the
+// worker's methods that look up a job take its key as the id.
+func Key(ns, id string) string {
+	if ns == "" || ns == DefaultNamespace {
+		return id
+	}
+	return ns + "/" + id
+}
+
+// SplitKey returns the namespace and id of a job key
+func SplitKey(key string) (string, string) {
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return DefaultNamespace, key
+}
@@ -0,0 +1,75 @@
+package worker
+
+// jobs whose spec sets MaxRunning wait in the worker's queue while
+// their owner already has that many running in the namespace. they
+// are started in the order they were submitted as the owner's other
+// jobs end.
+
+// runningCount returns the number of owner's jobs in namespace ns
+// that are running. caller must hold the worker lock.
+func (wkr *Worker) runningCount(ns, owner string) int {
+	n := 0
+	for _, job := range wkr.jobs {
+		if job.namespace == ns && job.Owner() == owner && job.Status() == running {
+			n++
+		}
+	}
+	return n
+}
+
+// admit starts job or queues it if its owner is at its limit or
+// already has jobs waiting, which start first.
+// caller must hold the worker lock.
+func (wkr *Worker) admit(job *job, maxRunning int) {
+	if maxRunning == 0 {
+		job.start()
+		return
+	}
+	job.maxRunning = maxRunning
+	wkr.queue = append(wkr.queue, job)
+	wkr.startQueued()
+}
+
+// dispatch starts queued jobs whose owners are below their limit and
+// drops jobs that were stopped while they waited
+func (wkr *Worker) dispatch() {
+	wkr.Lock()
+	defer wkr.Unlock()
+	wkr.startQueued()
+}
+
+// startQueued does the work of dispatch. an owner's jobs start in the
+// order they were queued: once one has to wait, so do the ones behind
+// it. caller must hold the worker lock.
+func (wkr *Worker) startQueued() {
+	if wkr.draining {
+		return
+	}
+
+	blocked := make(map[account]bool)
+	waiting := wkr.queue[:0]
+	for _, job := range wkr.queue {
+		if job.Status() != queued {
+			continue
+		}
+		acct := account{job.namespace, job.owner}
+		if !blocked[acct] && wkr.runningCount(job.namespace, job.owner) < job.maxRunning {
+			job.start()
+			continue
+		}
+		blocked[acct] = true
+		waiting = append(waiting, job)
+	}
+	wkr.queue = waiting
+}
+
+// cancelQueued cancels every job that is still waiting to start
+func (wkr *Worker) cancelQueued() {
+	wkr.Lock()
+	defer wkr.Unlock()
+
+	for _, job := range wkr.queue {
+		job.cancel()
+	}
+	wkr.queue = nil
+}
@@ -0,0 +1,128 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrJobActive is returned when removing a job that hasn't ended
+var ErrJobActive = errors.New("job has not ended")
+
+// RetentionPolicy limits how many finished jobs are kept.
+// zero fields are not enforced. jobs that haven't ended are never removed.
+type RetentionPolicy struct {
+	// MaxAge removes jobs that ended longer ago than this
+	MaxAge time.Duration `json:"maxAge,omitempty"`
+	// MaxCount keeps at most this many ended jobs, newest first
+	MaxCount int `json:"maxCount,omitempty"`
+	// MaxLogBytes caps the total output kept by ended jobs, newest first
+	MaxLogBytes int `json:"maxLogBytes,omitempty"`
+}
+
+// WithRetention sets the policy enforced by RunReaper
+func WithRetention(policy RetentionPolicy) Option {
+	return func(wkr *Worker) {
+		wkr.retention = policy
+	}
+}
+
+// RemoveJob deletes the record of job matching id.
+// only jobs that have ended can be removed.
+func (wkr *Worker) RemoveJob(id string) error {
+	wkr.Lock()
+	defer wkr.Unlock()
+
+	job, ok := wkr.jobs[id]
+	if !ok {
+		return notFoundError(id)
+	}
+	if !isTerminal(job.Status()) {
+		return ErrJobActive
+	}
+
+	delete(wkr.jobs, id)
+	wkr.unpersist(id)
+	wkr.removeArtifacts(id)
+	return nil
+}
+
+// Prune removes ended jobs in namespaces that fall outside policy and
+// returns their ids. the policy only counts jobs in namespaces. nil
+// namespaces prunes every namespace.
+func (wkr *Worker) Prune(policy RetentionPolicy, namespaces []string) []string {
+	var only map[string]bool
+	if namespaces != nil {
+		only = make(map[string]bool)
+		for _, ns := range namespaces {
+			only[ns] = true
+		}
+	}
+
+	wkr.Lock()
+	defer wkr.Unlock()
+
+	type record struct {
+		id       string
+		ended    time.Time
+		logBytes int
+	}
+
+	// ended jobs, newest first
+	var records []record
+	for id, job := range wkr.jobs {
+		if only != nil && !only[job.namespace] {
+			continue
+		}
+		if ended, ok := job.EndedAt(); ok {
+			records = append(records, record{id, ended, job.LogBytes()})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ended.After(records[j].ended)
+	})
+
+	now := time.Now()
+	kept, logBytes := 0, 0
+	removed := []string{}
+	for _, rec := range records {
+		expired := policy.MaxAge > 0 && now.Sub(rec.ended) > policy.MaxAge
+		overCount := policy.MaxCount > 0 && kept >= policy.MaxCount
+		overBytes := policy.MaxLogBytes > 0 && logBytes+rec.logBytes > policy.MaxLogBytes
+		if expired || overCount || overBytes {
+			delete(wkr.jobs, rec.id)
+			wkr.unpersist(rec.id)
+			wkr.removeArtifacts(rec.id)
+			removed = append(removed, rec.id)
+			continue
+		}
+
+		kept++
+		logBytes += rec.logBytes
+	}
+
+	sortIDs(removed)
+	return removed
+}
+
+// RetentionPolicy returns the policy enforced by RunReaper
+func (wkr *Worker) RetentionPolicy() RetentionPolicy {
+	return wkr.retention
+}
+
+// RunReaper enforces the worker's retention policy every interval
+// until ctx is done.
+func (wkr *Worker) RunReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			wkr.Prune(wkr.retention, nil)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package worker
+
+import "syscall"
+
+// setRlimit sets a resource limit of the calling process
+func setRlimit(resource int, rlim syscall.Rlimit) error {
+	return syscall.Setrlimit(resource, &rlim)
+}
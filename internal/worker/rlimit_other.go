@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package worker
+
+import (
+	"errors"
+	"syscall"
+)
+
+// setRlimit is only supported on linux
+func setRlimit(resource int, rlim syscall.Rlimit) error {
+	return errors.New("resource limits are only supported on linux")
+}
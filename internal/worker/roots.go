@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// ErrDirNotAllowed is returned by StartJob for a spec whose Dir isn't
+// inside one of the worker's job roots
+var ErrDirNotAllowed = errors.New("working directory not allowed")
+
+// WithJobRoots lets jobs run in directories inside roots. without roots
+// jobs run in the server's working directory and can't keep artifacts,
+// which are read as the server user from wherever a job runs.
+func WithJobRoots(roots ...string) Option {
+	return func(wkr *Worker) {
+		wkr.roots = append(wkr.roots, roots...)
+	}
+}
+
+// CheckDir describes why dir can't be the working directory of a job,
+// or returns "" if it can. dir must be inside a job root once symlinks
+// are resolved.
+func (wkr *Worker) CheckDir(dir string) string {
+	if len(wkr.roots) == 0 {
+		return "jobs can't set their working directory on this server"
+	}
+	if _, err := inRoots(wkr.roots, dir); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// inRoots returns dir with symlinks resolved if it is one of roots or
+// inside one
+func inRoots(roots []string, dir string) (string, error) {
+	resolved, err := realPath(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range roots {
+		// roots may be symlinks too, or not exist yet
+		r, err := realPath(root)
+		if err == nil && inside(r, resolved) {
+			return resolved, nil
+		}
+	}
+	return "", errors.New(dir + " is not inside a job root")
+}
+
+// realPath returns p as an absolute path with symlinks resolved
+func realPath(p string) (string, error) {
+	p, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(p)
+}
+
+// inside reports whether p is root or below it. both must be clean
+// absolute paths.
+func inside(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// Secret is a value given to a job's process that must not show up in
+// its output
+type Secret struct {
+	// Env is set to Value, or to the path of a temp file holding Value
+	// when File is set. the file is removed when the job ends
+	Env   string
+	Value string
+	File  bool
+}
+
+// secretEnv returns the variables that give the job its secrets and
+// the temp files written for them. the caller removes the files.
+func secretEnv(secrets []Secret) (map[string]string, []string, error) {
+	env := make(map[string]string, len(secrets))
+	var files []string
+	for _, s := range secrets {
+		if !s.File {
+			env[s.Env] = s.Value
+			continue
+		}
+
+		// TempFile creates the file with mode 0600
+		f, err := ioutil.TempFile("", "ljw-secret-*")
+		if err != nil {
+			removeFiles(files)
+			return nil, nil, err
+		}
+		files = append(files, f.Name())
+		_, err = f.WriteString(s.Value)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			removeFiles(files)
+			return nil, nil, err
+		}
+		env[s.Env] = f.Name()
+	}
+	return env, files, nil
+}
+
+func removeFiles(files []string) {
+	for _, f := range files {
+		os.Remove(f)
+	}
+}
+
+// secretValues returns the values of secrets
+func secretValues(secrets []Secret) []string {
+	values := make([]string, len(secrets))
+	for i, s := range secrets {
+		values[i] = s.Value
+	}
+	return values
+}
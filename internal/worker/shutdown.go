@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"syscall"
+	"time"
+)
+
+// killGrace is how long jobs get to exit after SIGTERM before they
+// are sent SIGKILL during shutdown
+const killGrace = 5 * time.Second
+
+// Shutdown drains the worker. it stops accepting new jobs, cancels
+// queued ones and waits for running jobs to end until ctx is done. process groups still
+// running then get SIGTERM, followed by SIGKILL if they haven't exited
+// after a grace period. finally every job is written to the store.
+func (wkr *Worker) Shutdown(ctx context.Context) {
+	wkr.Drain()
+	wkr.cancelQueued()
+
+	active := wkr.activeJobs()
+	if !waitAll(ctx, active) {
+		log.Printf("drain timed out. terminating %d jobs", len(wkr.activeJobs()))
+		wkr.signalAll(syscall.SIGTERM)
+
+		graceCtx, cancel := context.WithTimeout(context.Background(), killGrace)
+		defer cancel()
+		if !waitAll(graceCtx, active) {
+			log.Printf("killing %d jobs", len(wkr.activeJobs()))
+			wkr.signalAll(syscall.SIGKILL)
+			waitAll(context.Background(), active)
+		}
+	}
+
+	wkr.persistAll()
+}
+
+// activeJobs returns the jobs that haven't ended
+func (wkr *Worker) activeJobs() []*job {
+	wkr.RLock()
+	defer wkr.RUnlock()
+
+	var active []*job
+	for _, job := range wkr.jobs {
+		if !isTerminal(job.Status()) {
+			active = append(active, job)
+		}
+	}
+	return active
+}
+
+// waitAll waits for jobs to end and reports whether they all did before ctx was done
+func waitAll(ctx context.Context, jobs []*job) bool {
+	for _, job := range jobs {
+		select {
+		case <-job.Done():
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// signalAll sends sig to the process group of every running job
+func (wkr *Worker) signalAll(sig syscall.Signal) {
+	for _, job := range wkr.activeJobs() {
+		if err := job.signal(sig); err != nil {
+			log.Printf("could not signal job %s. error: %v", job.id, err)
+		}
+	}
+}
+
+// persistAll writes every job to the store
+func (wkr *Worker) persistAll() {
+	wkr.RLock()
+	defer wkr.RUnlock()
+
+	for _, job := range wkr.jobs {
+		if isTerminal(job.Status()) {
+			wkr.persist(job)
+		}
+	}
+}
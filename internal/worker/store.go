@@ -0,0 +1,226 @@
+package worker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// record is the on-disk form of a job that has ended
+type record struct {
+	ID         string            `json:"id"`
+	Namespace  string            `json:"namespace,omitempty"`
+	Owner      string            `json:"owner,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Cmd        []string          `json:"cmd"`
+	Status     string            `json:"status"`
+	Output     []string          `json:"output"`
+	ExitCode   int               `json:"exitCode"`
+	Created    time.Time         `json:"created"`
+	Started    time.Time         `json:"started,omitempty"`
+	Ended      time.Time         `json:"ended"`
+	UserTime   time.Duration     `json:"userTime,omitempty"`
+	SystemTime time.Duration     `json:"systemTime,omitempty"`
+	Deliveries []Delivery        `json:"deliveries,omitempty"`
+	Redactions int               `json:"redactions,omitempty"`
+	Artifacts  []Artifact        `json:"artifacts,omitempty"`
+}
+
+// store keeps records of ended jobs as one JSON file per job in dir.
+// jobs outside the default namespace are kept in a directory per namespace.
+type store struct {
+	dir string
+}
+
+// WithDataDir stores ended jobs in dir so they survive restarts.
+// jobs already stored there are loaded by New.
+func WithDataDir(dir string) Option {
+	return func(wkr *Worker) {
+		wkr.store = &store{dir: dir}
+	}
+}
+
+func (st *store) jobsDir() string {
+	return filepath.Join(st.dir, "jobs")
+}
+
+// path returns the file of the job with key
+func (st *store) path(key string) string {
+	return filepath.Join(st.jobsDir(), filepath.FromSlash(key)+".json")
+}
+
+// save writes rec, replacing any earlier record of the same job
+func (st *store) save(rec record) error {
+	file := st.path(Key(rec.Namespace, rec.ID))
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file first so a crash never leaves half a record
+	tmp, err := ioutil.TempFile(dir, rec.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// remove deletes the record of the job with key if there is one
+func (st *store) remove(key string) error {
+	err := os.Remove(st.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// load reads every stored record
+func (st *store) load() ([]record, error) {
+	files, err := filepath.Glob(filepath.Join(st.jobsDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	nsFiles, err := filepath.Glob(filepath.Join(st.jobsDir(), "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, nsFiles...)
+
+	var records []record
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		var rec record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// writable checks that records can still be written
+func (st *store) writable() error {
+	if err := os.MkdirAll(st.jobsDir(), 0700); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(st.jobsDir(), ".probe.*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// record returns the on-disk form of the job
+func (j *job) record() record {
+	j.RLock()
+	defer j.RUnlock()
+
+	return record{
+		ID:         j.id,
+		Namespace:  j.namespace,
+		Owner:      j.owner,
+		Labels:     j.labels,
+		Cmd:        j.cmd,
+		Status:     j.status,
+		Output:     append([]string(nil), j.output...),
+		ExitCode:   j.exitCode,
+		Created:    j.created,
+		Started:    j.started,
+		Ended:      j.ended,
+		UserTime:   j.userTime,
+		SystemTime: j.sysTime,
+		Deliveries: append([]Delivery(nil), j.deliveries...),
+		Redactions: j.redactions,
+		Artifacts:  append([]Artifact(nil), j.artifacts...),
+	}
+}
+
+// persist saves the job's record if the worker has a store
+func (wkr *Worker) persist(j *job) {
+	if wkr.store == nil {
+		return
+	}
+	if err := wkr.store.save(j.record()); err != nil {
+		log.Printf("could not store job %s. error: %v", j.key(), err)
+	}
+}
+
+// unpersist removes the stored record of the job with key if the
+// worker has a store
+func (wkr *Worker) unpersist(key string) {
+	if wkr.store == nil {
+		return
+	}
+	if err := wkr.store.remove(key); err != nil {
+		log.Printf("could not remove stored job %s. error: %v", key, err)
+	}
+}
+
+// restoreJob rebuilds an ended job from its record
+func restoreJob(rec record, events *eventBus, m *workerMetrics) *job {
+	j := newJob(rec.ID, Spec{Namespace: rec.Namespace, Cmd: rec.Cmd, Owner: rec.Owner, Labels: rec.Labels}, events, m)
+	j.status = rec.Status
+	j.output = rec.Output
+	j.exitCode = rec.ExitCode
+	j.created = rec.Created
+	j.started = rec.Started
+	j.ended = rec.Ended
+	j.userTime = rec.UserTime
+	j.sysTime = rec.SystemTime
+	j.deliveries = rec.Deliveries
+	j.redactions = rec.Redactions
+	j.artifacts = rec.Artifacts
+	close(j.done)
+	return j
+}
+
+// LoadJobs adds jobs kept in the data dir to the worker and moves
+// the id counter of each namespace past them. jobs that ended today count towards their
+// owner's cpu usage again. does nothing without WithDataDir.
+func (wkr *Worker) LoadJobs() error {
+	if wkr.store == nil {
+		return nil
+	}
+
+	wkr.Lock()
+	defer wkr.Unlock()
+
+	records, err := wkr.store.load()
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		job := restoreJob(rec, wkr.events, wkr.metrics)
+		wkr.jobs[job.key()] = job
+		wkr.cpu.charge(job.namespace, rec.Owner, rec.UserTime+rec.SystemTime, rec.Ended)
+		if n, err := strconv.Atoi(rec.ID); err == nil && n > wkr.ids[job.namespace] {
+			wkr.ids[job.namespace] = n
+		}
+	}
+	return nil
+}
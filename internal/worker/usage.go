@@ -0,0 +1,98 @@
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+// Usage is how much of the worker one owner's jobs in a namespace are using
+type Usage struct {
+	Running int
+	Queued  int
+	// CPU is the cpu time used by the owner's jobs that ended today (UTC)
+	CPU time.Duration
+	// CPUReset is when CPU goes back to zero
+	CPUReset time.Time
+}
+
+// cpuLedger adds up the cpu time of ended jobs per owner and namespace
+// for the current UTC day. it outlives the jobs so purging doesn't
+// reset it.
+type cpuLedger struct {
+	mu   sync.Mutex
+	day  time.Time
+	used map[account]time.Duration
+}
+
+// account is an owner in a namespace
+type account struct {
+	namespace string
+	owner     string
+}
+
+func newCPULedger() *cpuLedger {
+	return &cpuLedger{used: make(map[account]time.Duration)}
+}
+
+// startOfDay returns midnight UTC of the day t falls on
+func startOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// rollover starts a new day if now is past the current one.
+// caller must hold the lock.
+func (l *cpuLedger) rollover(now time.Time) {
+	if day := startOfDay(now); day.After(l.day) {
+		l.day = day
+		l.used = make(map[account]time.Duration)
+	}
+}
+
+// charge adds d to the cpu time owner used in namespace ns on the day of at
+func (l *cpuLedger) charge(ns, owner string, d time.Duration, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rollover(at)
+	if startOfDay(at).Equal(l.day) {
+		l.used[account{ns, owner}] += d
+	}
+}
+
+// today returns the cpu time owner has used in namespace ns today
+func (l *cpuLedger) today(ns, owner string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rollover(now)
+	return l.used[account{ns, owner}]
+}
+
+// Usage returns the jobs owner has running and queued in namespace ns
+// and the cpu time its jobs there have used today
+func (wkr *Worker) Usage(ns, owner string) Usage {
+	if ns == "" {
+		ns = DefaultNamespace
+	}
+
+	wkr.RLock()
+	defer wkr.RUnlock()
+
+	now := time.Now()
+	u := Usage{
+		CPU:      wkr.cpu.today(ns, owner, now),
+		CPUReset: startOfDay(now).Add(24 * time.Hour),
+	}
+	for _, job := range wkr.jobs {
+		if job.namespace != ns || job.Owner() != owner {
+			continue
+		}
+		switch job.Status() {
+		case running:
+			u.Running++
+		case queued:
+			u.Queued++
+		}
+	}
+	return u
+}
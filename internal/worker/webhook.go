@@ -0,0 +1,265 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the timestamp header,
+// a "." and the webhook body, keyed with the server's webhook secret,
+// as "sha256=<hex>".
+const SignatureHeader = "X-Ljw-Signature"
+
+// TimestampHeader carries the unix time a webhook request was sent at.
+// it is signed with the body so receivers can reject old requests
+// replayed to them.
+const TimestampHeader = "X-Ljw-Timestamp"
+
+// webhook delivery defaults
+const (
+	defaultWebhookAttempts = 5
+	defaultWebhookBackoff  = time.Second
+	webhookTimeout         = 10 * time.Second
+)
+
+// WebhookRule is a server-wide webhook. URL is called for every job
+// matching Filter when it reaches a terminal status.
+type WebhookRule struct {
+	URL    string
+	Filter EventFilter
+}
+
+// WebhookConfig configures webhook delivery.
+type WebhookConfig struct {
+	// Secret signs payloads. no signature header is sent if empty.
+	Secret []byte
+	// Rules are webhooks applied to all matching jobs
+	Rules []WebhookRule
+	// MaxAttempts per url. defaults to 5
+	MaxAttempts int
+	// Backoff before the first retry. doubles after each attempt. defaults to 1s
+	Backoff time.Duration
+	// AllowHosts are hosts, as host or host:port, that the webhooks of a
+	// job may call even if they resolve to a loopback, private or
+	// link-local address. server-wide rules may call any host.
+	AllowHosts []string
+}
+
+// WebhookPayload is the JSON body POSTed to webhook urls
+type WebhookPayload struct {
+	Event     string            `json:"event"`
+	JobID     string            `json:"jobId"`
+	Namespace string            `json:"namespace"`
+	Owner     string            `json:"owner,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Cmd       []string          `json:"cmd"`
+	Status    string            `json:"status"`
+	ExitCode  int               `json:"exitCode"`
+	Time      time.Time         `json:"time"`
+}
+
+// Delivery records one attempt to call a webhook
+type Delivery struct {
+	URL        string    `json:"url"`
+	Attempt    int       `json:"attempt"`
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Success    bool      `json:"success"`
+}
+
+// WithWebhooks enables webhook delivery using cfg
+func WithWebhooks(cfg WebhookConfig) Option {
+	return func(wkr *Worker) {
+		if cfg.MaxAttempts <= 0 {
+			cfg.MaxAttempts = defaultWebhookAttempts
+		}
+		if cfg.Backoff <= 0 {
+			cfg.Backoff = defaultWebhookBackoff
+		}
+		wkr.webhooks = cfg
+	}
+}
+
+// errPrivateAddress is returned when a job's webhook would connect to
+// an address it isn't allowed to reach
+var errPrivateAddress = errors.New("is a loopback, private or link-local address, which job webhooks may not call")
+
+// privateNets are the ranges job webhooks may not call besides the
+// loopback, link-local and unspecified addresses
+var privateNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// sign returns the signature header value for body sent at timestamp
+func sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookClient returns a client for webhook requests. it doesn't
+// follow redirects, which could lead anywhere. with guarded set it
+// won't connect to loopback, private or link-local addresses. that is
+// checked on the address dialed, so a name resolving to a public
+// address when checked and a private one when used can't get through.
+func webhookClient(guarded bool) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	transport := &http.Transport{DialContext: dialer.DialContext, DisableKeepAlives: true}
+	if guarded {
+		dialer.Control = refusePrivate
+	} else {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// refusePrivate is a dialer control that fails connections to
+// loopback, private and link-local addresses
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%s %w", host, errPrivateAddress)
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return fmt.Errorf("%s %w", host, errPrivateAddress)
+		}
+	}
+	return nil
+}
+
+// allowedHost reports whether the host of rawURL is in AllowHosts
+func (cfg WebhookConfig) allowedHost(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	for _, h := range cfg.AllowHosts {
+		if h == u.Host || h == u.Hostname() {
+			return true
+		}
+	}
+	return false
+}
+
+// sendWebhooks POSTs the finished job to its own webhooks and any
+// matching server-wide rules, and returns once every delivery has
+// succeeded or run out of attempts. it reports whether any were made.
+func (wkr *Worker) sendWebhooks(j *job, payload WebhookPayload, urls []string) bool {
+	// the job's own webhooks were given by a client, so they may
+	// only call private addresses the server allows
+	open, guarded := webhookClient(false), webhookClient(true)
+	clients := make(map[string]*http.Client)
+	for _, u := range urls {
+		clients[u] = guarded
+		if wkr.webhooks.allowedHost(u) {
+			clients[u] = open
+		}
+	}
+	e := Event{JobID: payload.JobID, Namespace: payload.Namespace, Owner: payload.Owner, Labels: payload.Labels}
+	for _, rule := range wkr.webhooks.Rules {
+		if rule.Filter.match(e) {
+			urls = append(urls, rule.URL)
+			clients[rule.URL] = open
+		}
+	}
+	if len(urls) == 0 {
+		return false
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return false
+	}
+
+	var wg sync.WaitGroup
+	for _, u := range urls {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			wkr.deliver(clients[u], j, u, body)
+		}(u)
+	}
+	wg.Wait()
+	return true
+}
+
+// deliver POSTs body to url, retrying with exponential backoff until
+// a 2xx response or MaxAttempts is reached. every attempt is logged on j.
+// an address the job may not call isn't retried.
+func (wkr *Worker) deliver(client *http.Client, j *job, url string, body []byte) {
+	backoff := wkr.webhooks.Backoff
+	for attempt := 1; attempt <= wkr.webhooks.MaxAttempts; attempt++ {
+		d := Delivery{URL: url, Attempt: attempt, Time: time.Now().UTC()}
+
+		err := post(client, url, body, wkr.webhooks.Secret, &d)
+		if err != nil {
+			d.Error = err.Error()
+		}
+		j.logDelivery(d)
+
+		if d.Success || errors.Is(err, errPrivateAddress) {
+			return
+		}
+		if attempt < wkr.webhooks.MaxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// post sends one webhook request and records the response on d
+func post(client *http.Client, url string, body, secret []byte, d *Delivery) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, sign(secret, timestamp, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	d.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	d.Success = true
+	return nil
+}
@@ -1,12 +1,15 @@
 package worker
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sort"
 	"strconv"
-	"strings"
 	"sync"
+	"time"
 
+	"github.com/bradyfontenot/ljw/internal/redact"
 	"github.com/google/uuid"
 )
 
@@ -15,23 +18,142 @@ var idCounter int = 0
 
 // Worker is a store and task manager for all jobs
 type Worker struct {
-	// key serves as job id
+	// keyed by Key(namespace, id)
 	jobs map[string]*job
+	// last id given out in each namespace.
 	// should be replaced by UUID in production
-	currID int
+	ids       map[string]int
+	events    *eventBus
+	webhooks  WebhookConfig
+	retention RetentionPolicy
+	metrics   *workerMetrics
+	store     *store
+	// cpu is the cpu time used today by each owner's ended jobs
+	cpu *cpuLedger
+	// queue holds jobs waiting for their owner to drop below its
+	// MaxRunning, in the order they were started
+	queue []*job
+	// draining is set once the worker stops accepting new jobs
+	draining bool
+	// redact hides credentials in the output of every job
+	redact *redact.Rules
+	// artifacts is nil unless jobs may keep artifacts
+	artifacts *ArtifactConfig
+	// roots are the directories jobs may run in
+	roots []string
 	*sync.RWMutex
 }
 
+// ErrDraining is returned by StartJob once Drain has been called
+var ErrDraining = errors.New("worker is draining and not accepting new jobs")
+
+// ErrEmptyCommand is returned by StartJob for a spec without a command
+var ErrEmptyCommand = errors.New("command must not be empty")
+
+// ErrJobNotFound matches errors for ids that don't belong to a job
+var ErrJobNotFound = errors.New("job not found")
+
+// notFoundError is returned for an id that doesn't belong to a job
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e) + " is not a valid id"
+}
+
+func (e notFoundError) Is(target error) bool {
+	return target == ErrJobNotFound
+}
+
+// Option configures a Worker
+type Option func(*Worker)
+
+// WithRedaction hides text matching rules in the output of every job
+// before it is stored
+func WithRedaction(rules *redact.Rules) Option {
+	return func(wkr *Worker) {
+		wkr.redact = rules
+	}
+}
+
+// Spec describes a job to start
+type Spec struct {
+	// Namespace the job is started in. defaults to DefaultNamespace
+	Namespace string
+	Cmd       []string
+	// Owner is the identity of the client that submitted the job
+	Owner string
+	// Labels are free-form key/values used to filter jobs and events
+	Labels map[string]string
+	// Webhooks are urls POSTed to when the job ends
+	Webhooks []string
+	// Env is added to the environment the command runs in
+	Env map[string]string
+	// Secrets are added to the environment and hidden in the output.
+	// they aren't stored
+	Secrets []Secret
+	// Dir is the working directory of the command. it must be inside
+	// one of the worker's job roots. defaults to the worker's
+	Dir string
+	// Artifacts are globs of files in Dir kept once the job ends. they
+	// need Dir to be set
+	Artifacts []string
+	// MaxRunning caps how many of the owner's jobs in the namespace run
+	// at once. the job waits queued while the owner is at the cap. zero
+	// doesn't limit
+	MaxRunning int
+	// Limits cap the resources of the job's process
+	Limits Limits
+}
+
+// Info is a snapshot of a job's state
+type Info struct {
+	// ID is unique within Namespace
+	ID        string
+	Namespace string
+	Cmd       []string
+	Owner     string
+	Labels    map[string]string
+	Status    string
+	// ExitCode is nil until the job has ended
+	ExitCode *int
+	Created  time.Time
+	// Started and Ended are zero until the job gets there
+	Started time.Time
+	Ended   time.Time
+	// Redactions counts the matches of redaction rules hidden in the
+	// output
+	Redactions int
+}
+
+// Key returns the key the worker knows the job by
+func (info Info) Key() string {
+	return Key(info.Namespace, info.ID)
+}
+
+// Chunk is a run of output read by FollowJob
+type Chunk struct {
+	Lines []string
+	// Next is the offset to pass in on the following call
+	Next int
+}
+
 // New creates a new Worker
-func New() *Worker {
-	return &Worker{
-		make(map[string]*job),
-		0,
-		&sync.RWMutex{},
+func New(opts ...Option) *Worker {
+	wkr := &Worker{
+		jobs:    make(map[string]*job),
+		ids:     make(map[string]int),
+		events:  newEventBus(),
+		metrics: newWorkerMetrics(),
+		cpu:     newCPULedger(),
+		RWMutex: &sync.RWMutex{},
+	}
+	for _, opt := range opts {
+		opt(wkr)
 	}
+	return wkr
 }
 
-// ListJobs returns a list of jobs
+// ListJobs returns the keys of every job
 func (wkr *Worker) ListJobs() []string {
 	wkr.RLock()
 	defer wkr.RUnlock()
@@ -40,37 +162,155 @@ func (wkr *Worker) ListJobs() []string {
 	for id := range wkr.jobs {
 		list = append(list, id)
 	}
+	sortIDs(list)
+	return list
+}
+
+// Jobs returns a snapshot of every job in id order
+func (wkr *Worker) Jobs() []Info {
+	ids := wkr.ListJobs()
+
+	wkr.RLock()
+	defer wkr.RUnlock()
+
+	list := make([]Info, 0, len(ids))
+	for _, id := range ids {
+		if job, ok := wkr.jobs[id]; ok {
+			list = append(list, job.info())
+		}
+	}
+	return list
+}
+
+// sortIDs sorts job keys by namespace, default first, and then in
+// numeric id order
+func sortIDs(list []string) {
 	sort.Slice(list, func(i, j int) bool {
-		li, _ := strconv.Atoi(list[i])
-		lj, _ := strconv.Atoi(list[j])
+		nsi, idi := SplitKey(list[i])
+		nsj, idj := SplitKey(list[j])
+		if nsi != nsj {
+			return nsi == DefaultNamespace || (nsj != DefaultNamespace && nsi < nsj)
+		}
+
+		li, _ := strconv.Atoi(idi)
+		lj, _ := strconv.Atoi(idj)
 
 		return li < lj
 	})
-	return list
 }
 
 // StartJob initializes a new job and makes call to start the proc
-// return props of new job
-func (wkr *Worker) StartJob(cmd []string) map[string]string {
+// return info of new job
+func (wkr *Worker) StartJob(spec Spec) (Info, error) {
 	wkr.Lock()
 	defer wkr.Unlock()
 
+	if wkr.draining {
+		return Info{}, ErrDraining
+	}
+	if len(spec.Cmd) == 0 || spec.Cmd[0] == "" {
+		return Info{}, ErrEmptyCommand
+	}
+	if len(spec.Artifacts) > 0 && wkr.artifacts == nil {
+		return Info{}, ErrArtifactsDisabled
+	}
+	if len(spec.Artifacts) > 0 && spec.Dir == "" {
+		return Info{}, fmt.Errorf("artifacts are only kept from a job root. %w", ErrDirNotAllowed)
+	}
+	if spec.Dir != "" {
+		if msg := wkr.CheckDir(spec.Dir); msg != "" {
+			return Info{}, fmt.Errorf("%s. %w", msg, ErrDirNotAllowed)
+		}
+	}
+
+	if spec.Namespace == "" {
+		spec.Namespace = DefaultNamespace
+	}
+
 	id := uuid.New().String()
 
 	// temp. replace w/ UUID in prod
-	wkr.currID++
-	id = strconv.Itoa(wkr.currID)
+	wkr.ids[spec.Namespace]++
+	id = strconv.Itoa(wkr.ids[spec.Namespace])
+	key := Key(spec.Namespace, id)
+
+	wkr.jobs[key] = newJob(id, spec, wkr.events, wkr.metrics)
+	job := wkr.jobs[key]
+	job.cpu = wkr.cpu
+	job.rules = wkr.redact
+	if len(spec.Artifacts) > 0 {
+		job.artifactDir = wkr.artifactDir(key)
+		job.artifactCfg = *wkr.artifacts
+		job.roots = wkr.roots
+	}
 
-	wkr.jobs[id] = newJob(cmd)
-	job := wkr.jobs[id]
+	job.onFinish = func() {
+		// the owner may have jobs waiting for this one to end
+		wkr.dispatch()
 
-	job.start()
-	return map[string]string{
-		"id":     id,
-		"cmd":    strings.Join(job.Cmd(), " "),
-		"status": job.Status(),
-		"output": strings.Join(job.Output(), " "),
+		job.RLock()
+		payload := WebhookPayload{
+			Event:     "job." + EventFinished,
+			JobID:     id,
+			Namespace: job.namespace,
+			Owner:     job.owner,
+			Labels:    job.labels,
+			Cmd:       job.cmd,
+			Status:    job.status,
+			ExitCode:  job.exitCode,
+			Time:      time.Now().UTC(),
+		}
+		urls := append([]string(nil), job.webhooks...)
+		job.RUnlock()
+
+		save := func() {
+			// hold the worker lock so a purge can't race the save
+			wkr.RLock()
+			if wkr.jobs[key] == job {
+				wkr.persist(job)
+			}
+			wkr.RUnlock()
+		}
+		save()
+		// save again once the delivery log is complete
+		if wkr.sendWebhooks(job, payload, urls) {
+			save()
+		}
 	}
+
+	job.Lock()
+	job.emit(EventCreated, "")
+	job.emit(EventQueued, "")
+	job.Unlock()
+
+	wkr.admit(job, spec.MaxRunning)
+	return job.info(), nil
+}
+
+// Drain stops the worker from accepting new jobs.
+// jobs already started keep running.
+func (wkr *Worker) Drain() {
+	wkr.Lock()
+	defer wkr.Unlock()
+	wkr.draining = true
+}
+
+// Ready returns an error describing why the worker can't take new
+// jobs, or nil if it can.
+func (wkr *Worker) Ready() error {
+	wkr.RLock()
+	draining := wkr.draining
+	wkr.RUnlock()
+
+	if draining {
+		return ErrDraining
+	}
+	if wkr.store != nil {
+		if err := wkr.store.writable(); err != nil {
+			return fmt.Errorf("job store is not writable: %w", err)
+		}
+	}
+	return nil
 }
 
 // StopJob will cancel job if still running or queued
@@ -80,7 +320,7 @@ func (wkr *Worker) StopJob(id string) (bool, error) {
 
 	job, ok := wkr.jobs[id]
 	if !ok {
-		return false, errors.New(id + " is not a valid id")
+		return false, notFoundError(id)
 	}
 
 	result, err := job.stop()
@@ -91,20 +331,89 @@ func (wkr *Worker) StopJob(id string) (bool, error) {
 	return result, nil
 }
 
-// GetJob returns a map of job props for the matching id
-func (wkr *Worker) GetJob(id string) (map[string]string, error) {
+// GetJob returns the info of job matching id
+func (wkr *Worker) GetJob(id string) (Info, error) {
 	wkr.RLock()
 	defer wkr.RUnlock()
 
 	job, ok := wkr.jobs[id]
 	if !ok {
-		return nil, errors.New(id + " is not a valid id")
+		return Info{}, notFoundError(id)
+	}
+
+	return job.info(), nil
+}
+
+// JobOutput returns all output written by job matching id
+func (wkr *Worker) JobOutput(id string) ([]string, error) {
+	wkr.RLock()
+	job, ok := wkr.jobs[id]
+	wkr.RUnlock()
+	if !ok {
+		return nil, notFoundError(id)
+	}
+
+	lines, _, _ := job.outputSince(0)
+	return lines, nil
+}
+
+// FollowJob returns the output written by job matching id since offset.
+// blocks until new output is available, the job ends or ctx is done.
+// the info is taken along with the output, so once it shows the job
+// has ended the chunk holds the last of its output.
+func (wkr *Worker) FollowJob(ctx context.Context, id string, offset int) (Info, Chunk, error) {
+	wkr.RLock()
+	job, ok := wkr.jobs[id]
+	wkr.RUnlock()
+	if !ok {
+		return Info{}, Chunk{}, notFoundError(id)
+	}
+
+	if offset < 0 {
+		offset = 0
+	}
+
+	for {
+		lines, info, notify := job.outputSince(offset)
+		chunk := Chunk{Lines: lines, Next: offset + len(lines)}
+		if len(lines) > 0 || isTerminal(info.Status) {
+			return info, chunk, nil
+		}
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return info, chunk, nil
+		}
+	}
+}
+
+// WaitJob blocks until job matching id ends or ctx is done and then
+// returns its info. callers check Status to see which happened.
+func (wkr *Worker) WaitJob(ctx context.Context, id string) (Info, error) {
+	wkr.RLock()
+	job, ok := wkr.jobs[id]
+	wkr.RUnlock()
+	if !ok {
+		return Info{}, notFoundError(id)
+	}
+
+	select {
+	case <-job.Done():
+	case <-ctx.Done():
+	}
+
+	return job.info(), nil
+}
+
+// JobWebhooks returns the webhook delivery log of job matching id
+func (wkr *Worker) JobWebhooks(id string) ([]Delivery, error) {
+	wkr.RLock()
+	job, ok := wkr.jobs[id]
+	wkr.RUnlock()
+	if !ok {
+		return nil, notFoundError(id)
 	}
 
-	return map[string]string{
-		"id":     id,
-		"cmd":    strings.Join(job.Cmd(), " "),
-		"status": job.Status(),
-		"output": strings.Join(job.Output(), " "),
-	}, nil
+	return job.Deliveries(), nil
 }